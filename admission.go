@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Admission webhooks served next to conversion, for foos and bars. Scenarios
+// named e.g. Benchmark_CreateLatency_CRWithConvert_AdmissionBoth go through
+// both, AdmissionValidating and AdmissionMutating through one of them.
+var (
+	admissionConfigName = "foos-and-bars.stable.example.com"
+	validatePath        = "/validate"
+	mutatePath          = "/mutate"
+
+	// objects with this annotation are rejected by the validating webhook
+	rejectAnnotation = "stable.example.com/reject"
+	// the mutating webhook sets this annotation on every object
+	mutatedAnnotation = "stable.example.com/mutated"
+)
+
+// admissionScenario parses which admission webhooks a benchmark goes through
+func admissionScenario(name string) (validating, mutating bool) {
+	both := strings.Contains(name, "AdmissionBoth")
+	return both || strings.Contains(name, "AdmissionValidating"), both || strings.Contains(name, "AdmissionMutating")
+}
+
+// admitFunc reviews obj, returning a JSON patch to apply to it if any, or an
+// error if it is rejected
+type admitFunc func(obj *unstructured.Unstructured) ([]byte, error)
+
+// admissionHandler serves AdmissionReviews using admit on the reviewed object
+func admissionHandler(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review := admissionv1beta1.AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "admission review has no request", http.StatusBadRequest)
+			return
+		}
+		review.Response = admitObject(review.Request, admit)
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&review); err != nil {
+			fmt.Printf("failed to write admission response: %v\n", err)
+		}
+	}
+}
+
+func admitObject(req *admissionv1beta1.AdmissionRequest, admit admitFunc) *admissionv1beta1.AdmissionResponse {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(req.Object.Raw); err != nil {
+		return &admissionv1beta1.AdmissionResponse{
+			UID:    req.UID,
+			Result: &metav1.Status{Status: metav1.StatusFailure, Message: err.Error(), Code: http.StatusBadRequest},
+		}
+	}
+	patch, err := admit(obj)
+	if err != nil {
+		return &admissionv1beta1.AdmissionResponse{
+			UID: req.UID,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+			},
+		}
+	}
+	resp := &admissionv1beta1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if patch != nil {
+		patchType := admissionv1beta1.PatchTypeJSONPatch
+		resp.Patch, resp.PatchType = patch, &patchType
+	}
+	return resp
+}
+
+// validateObject rejects objects with rejectAnnotation, and objects whose spec
+// is not an object
+func validateObject(obj *unstructured.Unstructured) ([]byte, error) {
+	if _, ok := obj.GetAnnotations()[rejectAnnotation]; ok {
+		return nil, fmt.Errorf("%s has annotation %s", obj.GetName(), rejectAnnotation)
+	}
+	if spec, ok := obj.Object["spec"]; ok {
+		if _, ok := spec.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s has a spec that is not an object", obj.GetName())
+		}
+	}
+	return nil, nil
+}
+
+// mutateObject sets mutatedAnnotation
+func mutateObject(obj *unstructured.Unstructured) ([]byte, error) {
+	var patch []map[string]interface{}
+	if obj.GetAnnotations() == nil {
+		patch = append(patch, map[string]interface{}{
+			"op": "add", "path": "/metadata/annotations", "value": map[string]string{mutatedAnnotation: "true"},
+		})
+	} else {
+		patch = append(patch, map[string]interface{}{
+			"op": "add", "path": "/metadata/annotations/" + escapeJSONPointer(mutatedAnnotation), "value": "true",
+		})
+	}
+	return json.Marshal(patch)
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// setupAdmission makes sure foos and bars go through the given admission
+// webhooks, and no others of ours
+func setupAdmission(validating, mutating bool) error {
+	clientset, err := newClientset()
+	if err != nil {
+		return err
+	}
+	secret, err := clientset.CoreV1().Secrets(webhookNamespace).Get(webhookSecretName, metav1.GetOptions{})
+	if err != nil && (validating || mutating) {
+		return fmt.Errorf("failed to read the webhook CA: %v", err)
+	}
+	var caBundle []byte
+	if secret != nil {
+		caBundle = secretCABundle(secret)
+	}
+	if err := ensureValidatingWebhook(clientset, validating, caBundle); err != nil {
+		return err
+	}
+	if err := ensureMutatingWebhook(clientset, mutating, caBundle); err != nil {
+		return err
+	}
+	return waitForAdmission(validating, mutating, *crdReadyTimeout)
+}
+
+// admissionWebhook builds the webhook for foos and bars served at path
+func admissionWebhook(name, path string, caBundle []byte) admissionregistrationv1beta1.Webhook {
+	failurePolicy := admissionregistrationv1beta1.Fail
+	// no side effects, so dry-run requests, e.g. waitForAdmission, are admitted
+	sideEffects := admissionregistrationv1beta1.SideEffectClassNone
+	return admissionregistrationv1beta1.Webhook{
+		Name: name,
+		ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+			// the vendored service reference has no port, so the apiserver
+			// calls port 443, the admission port of webhookService
+			Service: &admissionregistrationv1beta1.ServiceReference{
+				Namespace: webhookNamespace,
+				Name:      webhookServiceName,
+				Path:      &path,
+			},
+			CABundle: caBundle,
+		},
+		Rules: []admissionregistrationv1beta1.RuleWithOperations{{
+			Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update},
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   []string{"stable.example.com"},
+				APIVersions: []string{"*"},
+				Resources:   []string{"foos", "bars"},
+			},
+		}},
+		FailurePolicy: &failurePolicy,
+		SideEffects:   &sideEffects,
+	}
+}
+
+// ensureValidatingWebhook creates or updates the validating webhook
+// configuration if enabled, or deletes it otherwise
+func ensureValidatingWebhook(clientset *kubernetes.Clientset, enabled bool, caBundle []byte) error {
+	client := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	config, err := client.Get(admissionConfigName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !enabled {
+			return nil
+		}
+		_, err = client.Create(&admissionregistrationv1beta1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: admissionConfigName},
+			Webhooks:   []admissionregistrationv1beta1.Webhook{admissionWebhook("validate.stable.example.com", validatePath, caBundle)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return client.Delete(admissionConfigName, &metav1.DeleteOptions{})
+	}
+	webhooks := []admissionregistrationv1beta1.Webhook{admissionWebhook("validate.stable.example.com", validatePath, caBundle)}
+	if apiequality.Semantic.DeepEqual(config.Webhooks, webhooks) {
+		return nil
+	}
+	config.Webhooks = webhooks
+	_, err = client.Update(config)
+	return err
+}
+
+// ensureMutatingWebhook creates or updates the mutating webhook
+// configuration if enabled, or deletes it otherwise
+func ensureMutatingWebhook(clientset *kubernetes.Clientset, enabled bool, caBundle []byte) error {
+	client := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	config, err := client.Get(admissionConfigName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !enabled {
+			return nil
+		}
+		_, err = client.Create(&admissionregistrationv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: admissionConfigName},
+			Webhooks:   []admissionregistrationv1beta1.Webhook{admissionWebhook("mutate.stable.example.com", mutatePath, caBundle)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return client.Delete(admissionConfigName, &metav1.DeleteOptions{})
+	}
+	webhooks := []admissionregistrationv1beta1.Webhook{admissionWebhook("mutate.stable.example.com", mutatePath, caBundle)}
+	if apiequality.Semantic.DeepEqual(config.Webhooks, webhooks) {
+		return nil
+	}
+	config.Webhooks = webhooks
+	_, err = client.Update(config)
+	return err
+}
+
+// patchAdmissionCABundles sets caBundle on our admission webhook configurations
+func patchAdmissionCABundles(clientset *kubernetes.Clientset, caBundle []byte) ([]string, error) {
+	var patched []string
+	validating := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	if config, err := validating.Get(admissionConfigName, metav1.GetOptions{}); err == nil {
+		for i := range config.Webhooks {
+			config.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if _, err := validating.Update(config); err != nil {
+			return patched, fmt.Errorf("failed to update caBundle of validating webhook %s: %v", admissionConfigName, err)
+		}
+		patched = append(patched, "validatingwebhookconfiguration/"+admissionConfigName)
+	} else if !errors.IsNotFound(err) {
+		return patched, err
+	}
+	mutating := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	if config, err := mutating.Get(admissionConfigName, metav1.GetOptions{}); err == nil {
+		for i := range config.Webhooks {
+			config.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if _, err := mutating.Update(config); err != nil {
+			return patched, fmt.Errorf("failed to update caBundle of mutating webhook %s: %v", admissionConfigName, err)
+		}
+		patched = append(patched, "mutatingwebhookconfiguration/"+admissionConfigName)
+	} else if !errors.IsNotFound(err) {
+		return patched, err
+	}
+	return patched, nil
+}
+
+// waitForAdmission dry-run creates a Foo with rejectAnnotation until the
+// apiserver rejects it if validating is true, and sets mutatedAnnotation on it
+// if mutating is true. Webhook configuration changes reach the apiserver
+// through an informer, so they take effect with a delay.
+func waitForAdmission(validating, mutating bool, timeout time.Duration) error {
+	probe, err := decodeUnstructured(foov1Template)
+	if err != nil {
+		return err
+	}
+	probe.SetName("admission-probe")
+	probe.SetAnnotations(map[string]string{rejectAnnotation: "true"})
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		return err
+	}
+	client := dynamicClient.Resource(foov1GVR).Namespace(emptyNamespace)
+
+	err = wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+		obj, err := client.Create(probe, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+		switch {
+		case err == nil:
+			_, mutated := obj.GetAnnotations()[mutatedAnnotation]
+			return !validating && mutated == mutating, nil
+		case errors.IsForbidden(err):
+			return validating, nil
+		default:
+			return false, err
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("admission webhooks not validating=%v mutating=%v: %v", validating, mutating, err)
+	}
+	if validating {
+		// the rejected probe can't show the mutation, so probe that separately
+		probe.SetAnnotations(nil)
+		err = wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+			obj, err := client.Create(probe, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+			if err != nil {
+				return false, err
+			}
+			_, mutated := obj.GetAnnotations()[mutatedAnnotation]
+			return mutated == mutating, nil
+		})
+		if err != nil {
+			return fmt.Errorf("mutating admission webhook not enabled=%v: %v", mutating, err)
+		}
+	}
+	return nil
+}
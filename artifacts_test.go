@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactsUpToDate(t *testing.T) {
+	for file, generated := range map[string][]byte{
+		"crd-template.yaml":    fooCRD,
+		"bar-crd.yaml":         barCRD,
+		"webhook-pod.yaml":     webhookPod,
+		"webhook-service.yaml": webhookService,
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("artifacts", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bytes.TrimSuffix(data, []byte("\n")), generated) {
+			t.Errorf("artifacts.go is out of date with artifacts/%s, run go generate", file)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// servingCerts is a CA and a serving certificate signed by it, PEM encoded
+type servingCerts struct {
+	caCert []byte
+	cert   []byte
+	key    []byte
+}
+
+// runCerts creates a self-signed CA and a serving certificate for the webhook
+// service, stores them in the webhook secret and updates the caBundle of every
+// CRD converted by the webhook
+func runCerts(args []string) {
+	fs := flag.NewFlagSet("certs", flag.ExitOnError)
+	service := fs.String("service", webhookServiceName, "service name of the webhook")
+	namespace := fs.String("namespace", webhookNamespace, "namespace of the webhook service and secret")
+	secret := fs.String("secret", webhookSecretName, "secret to store the CA certificate and the serving certificate and key in")
+	validity := fs.Duration("validity", 365*24*time.Hour, "how long the certificates are valid")
+	fs.Parse(args)
+
+	if err := setupCerts(*service, *namespace, *secret, *validity); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// setupCerts does the work of runCerts
+func setupCerts(service, namespace, secret string, validity time.Duration) error {
+	certs, err := generateServingCerts(serviceHosts(service, namespace), validity)
+	if err != nil {
+		return err
+	}
+	clientset, err := newClientset()
+	if err != nil {
+		return err
+	}
+	if err := storeServingCerts(clientset, namespace, secret, certs); err != nil {
+		return err
+	}
+	fmt.Printf("stored serving certificate for %s.%s.svc in secret %s\n", service, namespace, secret)
+	patched, err := patchCABundles(service, namespace, certs.caCert)
+	if err != nil {
+		return err
+	}
+	for _, name := range patched {
+		fmt.Printf("updated caBundle of %s\n", name)
+	}
+	return nil
+}
+
+// serviceHosts lists the DNS names the apiserver may use to reach a service
+func serviceHosts(service, namespace string) []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s", service, namespace),
+		service,
+	}
+}
+
+// generateServingCerts creates a self-signed CA and a serving certificate for
+// the given DNS names and IP addresses, the first of which is the common name
+func generateServingCerts(hosts []string, validity time.Duration) (*servingCerts, error) {
+	now := time.Now()
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: "conversion-webhook-example-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano() + 1),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create serving certificate: %v", err)
+	}
+
+	return &servingCerts{
+		caCert: encodePEM("CERTIFICATE", caDER),
+		cert:   encodePEM("CERTIFICATE", der),
+		key:    encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+	}, nil
+}
+
+func encodePEM(blockType string, data []byte) []byte {
+	buf := bytes.Buffer{}
+	pem.Encode(&buf, &pem.Block{Type: blockType, Bytes: data})
+	return buf.Bytes()
+}
+
+// secretCABundle returns a copy of the CA certificate stored in a webhook secret
+func secretCABundle(secret *v1.Secret) []byte {
+	ca, ok := secret.Data["ca.pem"]
+	if !ok {
+		// secrets created by the old CSR based script only have the serving certificate
+		ca = secret.Data["cert.pem"]
+	}
+	return append([]byte{}, ca...)
+}
+
+// storeServingCerts creates or updates the secret mounted by the webhook pod
+func storeServingCerts(clientset *kubernetes.Clientset, namespace, name string, certs *servingCerts) error {
+	secrets := clientset.CoreV1().Secrets(namespace)
+	data := map[string][]byte{
+		"ca.pem":   certs.caCert,
+		"cert.pem": certs.cert,
+		"key.pem":  certs.key,
+	}
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = secrets.Create(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}, Data: data})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	secret.Data = data
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// patchCABundles sets caBundle on every CRD converted by given webhook service,
+// including the config kept for CRDs switched to strategy None, and on the
+// admission webhook configurations. A merge patch is used for CRDs so that
+// fields newer than the vendored apiextensions types are kept.
+func patchCABundles(service, namespace string, caBundle []byte) ([]string, error) {
+	crdClient, err := newCRDClient()
+	if err != nil {
+		return nil, err
+	}
+	crds, err := crdClient.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	client := dynamicClient.Resource(crdGVR)
+	var patched []string
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		patch := map[string]interface{}{}
+		if usesWebhookService(crd.Spec.Conversion, service, namespace) {
+			patch["spec"] = map[string]interface{}{
+				"conversion": map[string]interface{}{
+					"webhookClientConfig": map[string]interface{}{
+						"caBundle": base64.StdEncoding.EncodeToString(caBundle),
+					},
+				},
+			}
+		}
+		if data, ok := crd.Annotations[webhookConfigAnnotation]; ok {
+			// decoded as unstructured, like conversionFor does, so that fields
+			// newer than the vendored types are kept
+			conversion := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(data), &conversion); err != nil {
+				return patched, fmt.Errorf("failed to decode %s annotation of %s: %v", webhookConfigAnnotation, crd.Name, err)
+			}
+			name, _, _ := unstructured.NestedString(conversion, "webhookClientConfig", "service", "name")
+			serviceNamespace, _, _ := unstructured.NestedString(conversion, "webhookClientConfig", "service", "namespace")
+			if name == service && serviceNamespace == namespace {
+				conversion["webhookClientConfig"].(map[string]interface{})["caBundle"] = base64.StdEncoding.EncodeToString(caBundle)
+				data, err := json.Marshal(conversion)
+				if err != nil {
+					return patched, err
+				}
+				patch["metadata"] = map[string]interface{}{
+					"annotations": map[string]interface{}{webhookConfigAnnotation: string(data)},
+				}
+			}
+		}
+		if len(patch) == 0 {
+			continue
+		}
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return patched, err
+		}
+		if _, err := client.Patch(crd.Name, types.MergePatchType, data, metav1.UpdateOptions{}); err != nil {
+			return patched, fmt.Errorf("failed to update caBundle of %s: %v", crd.Name, err)
+		}
+		patched = append(patched, crd.Name)
+	}
+	if service != webhookServiceName || namespace != webhookNamespace {
+		return patched, nil
+	}
+	clientset, err := newClientset()
+	if err != nil {
+		return patched, err
+	}
+	admission, err := patchAdmissionCABundles(clientset, caBundle)
+	return append(patched, admission...), err
+}
+
+func usesWebhookService(conversion *v1beta1.CustomResourceConversion, service, namespace string) bool {
+	if conversion == nil || conversion.WebhookClientConfig == nil || conversion.WebhookClientConfig.Service == nil {
+		return false
+	}
+	s := conversion.WebhookClientConfig.Service
+	return s.Name == service && s.Namespace == namespace
+}
+
+// rotateServingCerts replaces the webhook serving certificate and CA. The new
+// CA is trusted next to the old one until the webhook had settle time to pick
+// up the new certificate from its mounted secret, so that no conversion
+// request should fail during the rotation.
+func rotateServingCerts(settle time.Duration) error {
+	clientset, err := newClientset()
+	if err != nil {
+		return err
+	}
+	secret, err := clientset.CoreV1().Secrets(webhookNamespace).Get(webhookSecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	certs, err := generateServingCerts(serviceHosts(webhookServiceName, webhookNamespace), 365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	bundle := append(secretCABundle(secret), certs.caCert...)
+	if _, err := patchCABundles(webhookServiceName, webhookNamespace, bundle); err != nil {
+		return err
+	}
+	if err := storeServingCerts(clientset, webhookNamespace, webhookSecretName, certs); err != nil {
+		return err
+	}
+	// the kubelet syncs mounted secrets periodically, and the webhook polls the
+	// files, so there is no event to wait for
+	time.Sleep(settle)
+	_, err = patchCABundles(webhookServiceName, webhookNamespace, certs.caCert)
+	return err
+}
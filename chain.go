@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	clientv1beta1 "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The Chain CRD serves versions v1 to vN and stores the newest one. Every
+// version keeps the same value under a different field, spec.valueV<k>, so
+// each conversion has to rewrite the object.
+var (
+	chainName = "chains.stable.example.com"
+	chainPath = "/chainconvert"
+
+	// e.g. Benchmark_List_CRChain4_V1 reads v1 of a Chain CRD with 4 versions
+	chainScenarioRegexp = regexp.MustCompile(`CRChain(\d+)_V(\d+)`)
+)
+
+// chainScenario parses the number of Chain versions and the version to read
+// from a benchmark name
+func chainScenario(name string) (versions, read int, ok bool) {
+	m := chainScenarioRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return 0, 0, false
+	}
+	versions, _ = strconv.Atoi(m[1])
+	read, _ = strconv.Atoi(m[2])
+	return versions, read, true
+}
+
+func chainGVR(version int) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "stable.example.com", Version: chainVersionName(version), Resource: "chains"}
+}
+
+func chainTemplate(version int) []byte {
+	return []byte(fmt.Sprintf(`apiVersion: stable.example.com/%s
+kind: Chain
+metadata:
+  name: template
+spec:
+  %s: value`, chainVersionName(version), chainField(version)))
+}
+
+func chainVersionName(version int) string {
+	return fmt.Sprintf("v%d", version)
+}
+
+func chainField(version int) string {
+	return fmt.Sprintf("valueV%d", version)
+}
+
+// newChainConverter converts Chain objects through the hub version, the newest
+// of the given number of versions, so every conversion takes two steps no
+// matter how far apart the versions are
+func newChainConverter(versions int) convertFunc {
+	return func(obj *unstructured.Unstructured, toAPIVersion string) error {
+		from, err := parseChainVersion(obj.GetAPIVersion(), versions)
+		if err != nil {
+			return err
+		}
+		to, err := parseChainVersion(toAPIVersion, versions)
+		if err != nil {
+			return err
+		}
+		if err := moveChainValue(obj, from, versions); err != nil {
+			return err
+		}
+		return moveChainValue(obj, versions, to)
+	}
+}
+
+func parseChainVersion(apiVersion string, versions int) (int, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return 0, err
+	}
+	if gv.Group != "stable.example.com" || len(gv.Version) < 2 || gv.Version[0] != 'v' {
+		return 0, fmt.Errorf("unexpected conversion version %q", apiVersion)
+	}
+	version, err := strconv.Atoi(gv.Version[1:])
+	if err != nil || version < 1 || version > versions {
+		return 0, fmt.Errorf("unexpected conversion version %q, expected v1 to v%d", apiVersion, versions)
+	}
+	return version, nil
+}
+
+func moveChainValue(obj *unstructured.Unstructured, from, to int) error {
+	if from == to {
+		return nil
+	}
+	value, found, err := unstructured.NestedFieldNoCopy(obj.Object, "spec", chainField(from))
+	if err != nil || !found {
+		return err
+	}
+	unstructured.RemoveNestedField(obj.Object, "spec", chainField(from))
+	return unstructured.SetNestedField(obj.Object, value, "spec", chainField(to))
+}
+
+// setupChainCRD makes sure the Chain CRD serves the given number of versions
+func setupChainCRD(versions int) error {
+	client, err := newCRDClient()
+	if err != nil {
+		return err
+	}
+	return ensureChainCRD(client, versions)
+}
+
+// ensureChainCRD makes sure the Chain CRD has versions v1 to vN, storing vN.
+// A CRD with a different number of versions is deleted, together with all of
+// its objects, and created again.
+func ensureChainCRD(client clientv1beta1.CustomResourceDefinitionInterface, versions int) error {
+	crd, err := client.Get(chainName, metav1.GetOptions{})
+	if err == nil {
+		if len(crd.Spec.Versions) == versions {
+			return nil
+		}
+		if err := client.Delete(chainName, &metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		err := wait.PollImmediate(readyPollInterval, *crdReadyTimeout, func() (bool, error) {
+			_, err := client.Get(chainName, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != nil {
+			return fmt.Errorf("%s not deleted: %v", chainName, err)
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	foo, err := client.Get(fooName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if foo.Spec.Conversion == nil || foo.Spec.Conversion.WebhookClientConfig == nil || foo.Spec.Conversion.WebhookClientConfig.Service == nil {
+		return fmt.Errorf("%s has no webhook service to share with %s", fooName, chainName)
+	}
+	conversion := foo.Spec.Conversion.DeepCopy()
+	path := chainPath
+	conversion.WebhookClientConfig.Service.Path = &path
+
+	crd = &v1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: chainName},
+		Spec: v1beta1.CustomResourceDefinitionSpec{
+			Group:      "stable.example.com",
+			Names:      v1beta1.CustomResourceDefinitionNames{Kind: "Chain", Plural: "chains"},
+			Scope:      v1beta1.NamespaceScoped,
+			Conversion: conversion,
+		},
+	}
+	for i := 1; i <= versions; i++ {
+		crd.Spec.Versions = append(crd.Spec.Versions, v1beta1.CustomResourceDefinitionVersion{
+			Name:    chainVersionName(i),
+			Served:  true,
+			Storage: i == versions,
+		})
+	}
+	if _, err := client.Create(crd); err != nil {
+		return fmt.Errorf("failed to create %s: %v", chainName, err)
+	}
+	return waitForCRDReady(client, chainName, *crdReadyTimeout)
+}
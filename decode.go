@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// rawBenchmarkClient lists and gets with the REST client, and returns the
+// response body without decoding it, to measure the apiserver and the
+// transfer apart from client decoding. Its other operations are those of the
+// dynamic client.
+type rawBenchmarkClient struct {
+	BenchmarkClient
+	client      rest.Interface
+	path        string
+	listOptions *metav1.ListOptions
+}
+
+var _ BenchmarkClient = &rawBenchmarkClient{}
+
+// newRawBenchmarkClient creates objects from templateData like
+// newDynamicBenchmarkClient, and lists and gets them as raw bytes
+func newRawBenchmarkClient(config *rest.Config, gvr schema.GroupVersionResource, namespace string,
+	templateData []byte, listOptions *metav1.ListOptions, variation *objectVariation) (BenchmarkClient, error) {
+	c, err := newDynamicBenchmarkClient(config, gvr, namespace, templateData, listOptions, variation)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &rawBenchmarkClient{
+		BenchmarkClient: c,
+		client:          clientset.CoreV1().RESTClient(),
+		path:            resourcePath(gvr, namespace),
+		listOptions:     listOptions,
+	}, nil
+}
+
+// List returns the list response body as []byte
+func (c *rawBenchmarkClient) List() (interface{}, error) {
+	return c.client.Get().AbsPath(c.path).VersionedParams(c.listOptions, scheme.ParameterCodec).DoRaw()
+}
+
+// Get returns the response body of getting obj as []byte
+func (c *rawBenchmarkClient) Get(obj interface{}) (interface{}, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Get().AbsPath(c.path, m.GetName()).DoRaw()
+}
+
+// resourcePath is the API path of the resource in namespace
+func resourcePath(gvr schema.GroupVersionResource, namespace string) string {
+	prefix := "/apis/" + gvr.Group + "/" + gvr.Version
+	if gvr.Group == "" {
+		prefix = "/api/" + gvr.Version
+	}
+	return prefix + "/namespaces/" + namespace + "/" + gvr.Resource
+}
+
+// decodeUnstructuredList decodes a list like the dynamic client
+func decodeUnstructuredList(data []byte) (*unstructured.UnstructuredList, error) {
+	obj, err := runtime.Decode(unstructured.UnstructuredJSONScheme, data)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := obj.(*unstructured.UnstructuredList)
+	if !ok {
+		return nil, fmt.Errorf("decoded %T, want a list", obj)
+	}
+	return list, nil
+}
+
+// decodeUnstructuredObject decodes an object like the dynamic client
+func decodeUnstructuredObject(data []byte) (*unstructured.Unstructured, error) {
+	obj, err := runtime.Decode(unstructured.UnstructuredJSONScheme, data)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("decoded %T, want an object", obj)
+	}
+	return u, nil
+}
+
+// decodeEndpoints decodes an object like the typed client
+func decodeEndpoints(data []byte) (*v1.Endpoints, error) {
+	e := &v1.Endpoints{}
+	if _, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// decodeEndpointsList decodes a list like the typed client
+func decodeEndpointsList(data []byte) (*v1.EndpointsList, error) {
+	list := &v1.EndpointsList{}
+	if _, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+const endpointsList = `{"kind":"EndpointsList","apiVersion":"v1","metadata":{"resourceVersion":"42"},"items":[
+{"metadata":{"name":"a","namespace":"empty"},"subsets":[{"addresses":[{"ip":"10.0.0.1"}],"ports":[{"port":8080}]}]},
+{"metadata":{"name":"b","namespace":"empty"}}]}`
+
+func TestRawBenchmarkClientList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/empty/endpoints" || r.URL.Query().Get("resourceVersion") != "0" {
+			t.Errorf("got request %s, want a list of endpoints from the watch cache", r.URL)
+		}
+		w.Write([]byte(endpointsList))
+	}))
+	defer server.Close()
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:          server.URL,
+		APIPath:       "/api",
+		ContentConfig: rest.ContentConfig{GroupVersion: &v1.SchemeGroupVersion, NegotiatedSerializer: scheme.Codecs},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &rawBenchmarkClient{
+		client:      client,
+		path:        resourcePath(endpointsGVR, "empty"),
+		listOptions: &metav1.ListOptions{ResourceVersion: "0"},
+	}
+	obj, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := obj.([]byte); !ok || string(data) != endpointsList {
+		t.Errorf("got %v, want the raw response body", obj)
+	}
+}
+
+const endpoints = `{"kind":"Endpoints","apiVersion":"v1","metadata":{"name":"a","namespace":"empty","resourceVersion":"42"},"subsets":[{"addresses":[{"ip":"10.0.0.1"}],"ports":[{"port":8080}]}]}`
+
+func TestRawBenchmarkClientGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/empty/endpoints/a" {
+			t.Errorf("got request %s, want a get of endpoints a", r.URL)
+		}
+		w.Write([]byte(endpoints))
+	}))
+	defer server.Close()
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:          server.URL,
+		APIPath:       "/api",
+		ContentConfig: rest.ContentConfig{GroupVersion: &v1.SchemeGroupVersion, NegotiatedSerializer: scheme.Codecs},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &rawBenchmarkClient{
+		client: client,
+		path:   resourcePath(endpointsGVR, "empty"),
+	}
+	obj, err := c.Get(&v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := obj.([]byte); !ok || string(data) != endpoints {
+		t.Errorf("got %v, want the raw response body", obj)
+	}
+}
+
+func TestResourcePath(t *testing.T) {
+	if got, want := resourcePath(foov1GVR, "empty"), "/apis/stable.example.com/v1/namespaces/empty/foos"; got != want {
+		t.Errorf("got path %s, want %s", got, want)
+	}
+	if got, want := resourcePath(endpointsGVR, "empty"), "/api/v1/namespaces/empty/endpoints"; got != want {
+		t.Errorf("got path %s, want %s", got, want)
+	}
+}
+
+func TestDecodeList(t *testing.T) {
+	u, err := decodeUnstructuredList([]byte(endpointsList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Items) != 2 || u.GetResourceVersion() != "42" || u.Items[0].GetName() != "a" {
+		t.Errorf("got unstructured list %v", u)
+	}
+	e, err := decodeEndpointsList([]byte(endpointsList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Items) != 2 || e.ResourceVersion != "42" || e.Items[0].Subsets[0].Addresses[0].IP != "10.0.0.1" {
+		t.Errorf("got typed list %v", e)
+	}
+}
+
+func TestDecodeObject(t *testing.T) {
+	u, err := decodeUnstructuredObject([]byte(endpoints))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.GetName() != "a" || u.GetResourceVersion() != "42" {
+		t.Errorf("got unstructured object %v", u)
+	}
+	e, err := decodeEndpoints([]byte(endpoints))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "a" || e.Subsets[0].Addresses[0].IP != "10.0.0.1" {
+		t.Errorf("got typed object %v", e)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	keepGoing         = flag.Bool("keep-going", false, "count failed requests by error class instead of aborting the run on the first one")
+	errorRateInterval = flag.Duration("error-rate-interval", time.Second, "length of the intervals the error rate over time is reported in")
+)
+
+// classifyError names the class of a failed request: conversion and admission
+// webhook failures, which the apiserver reports as internal errors, the
+// StatusReason and HTTP status of other API errors, and client-side timeouts
+func classifyError(err error) string {
+	status, ok := err.(errors.APIStatus)
+	if !ok {
+		// client-go returns transport errors as a url.Error, which is a net.Error
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "ClientTimeout"
+		}
+		return "Other"
+	}
+	s := status.Status()
+	message := strings.ToLower(s.Message)
+	// not just "timeout", webhook URLs have a timeout parameter
+	timedOut := false
+	for _, timeout := range []string{"deadline exceeded", "timeout exceeded", "i/o timeout", "timed out"} {
+		timedOut = timedOut || strings.Contains(message, timeout)
+	}
+	var class string
+	switch {
+	case strings.Contains(message, "conversion webhook") && timedOut:
+		class = "ConversionWebhookTimeout"
+	case strings.Contains(message, "conversion webhook"):
+		class = "ConversionWebhookFailed"
+	case strings.Contains(message, "failed calling webhook") && timedOut:
+		class = "AdmissionWebhookTimeout"
+	case strings.Contains(message, "failed calling webhook"):
+		class = "AdmissionWebhookFailed"
+	case s.Reason != metav1.StatusReasonUnknown:
+		class = string(s.Reason)
+	default:
+		class = "Unknown"
+	}
+	return fmt.Sprintf("%s (%d)", class, s.Code)
+}
+
+// errorStats counts requests and failed requests by error class, in total and
+// per errorRateInterval since the stats were created. It is safe for
+// concurrent use.
+type errorStats struct {
+	lock     sync.Mutex
+	start    time.Time
+	interval time.Duration
+	requests int
+	classes  map[string]int
+	// requests and failures per interval since start
+	intervalRequests []int
+	intervalFailures []int
+}
+
+func newErrorStats() *errorStats {
+	interval := *errorRateInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &errorStats{start: time.Now(), interval: interval, classes: map[string]int{}}
+}
+
+// record counts a request that failed with err, or succeeded if err is nil
+func (s *errorStats) record(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := int(time.Since(s.start) / s.interval)
+	for len(s.intervalRequests) <= i {
+		s.intervalRequests = append(s.intervalRequests, 0)
+		s.intervalFailures = append(s.intervalFailures, 0)
+	}
+	s.requests++
+	s.intervalRequests[i]++
+	if err == nil {
+		return
+	}
+	s.classes[classifyError(err)]++
+	s.intervalFailures[i]++
+}
+
+// check records a request, and returns err unless --keep-going is set
+func (s *errorStats) check(err error) error {
+	s.record(err)
+	if *keepGoing {
+		return nil
+	}
+	return err
+}
+
+func (s *errorStats) failures() int {
+	n := 0
+	for _, count := range s.classes {
+		n += count
+	}
+	return n
+}
+
+// String reports the error counts by class and the error rate per interval
+func (s *errorStats) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	failures := s.failures()
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "errors: %d of %d requests (%s)\n", failures, s.requests, percent(failures, s.requests))
+	classes := make([]string, 0, len(s.classes))
+	for class := range s.classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(b, "  %s: %d\n", class, s.classes[class])
+	}
+	if failures == 0 {
+		return b.String()
+	}
+	fmt.Fprintf(b, "error rate per %v:\n", s.interval)
+	for i := range s.intervalRequests {
+		fmt.Fprintf(b, "  %v: %d of %d (%s)\n", time.Duration(i)*s.interval, s.intervalFailures[i], s.intervalRequests[i], percent(s.intervalFailures[i], s.intervalRequests[i]))
+	}
+	return b.String()
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", 100*float64(n)/float64(total))
+}
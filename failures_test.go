@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	foos := schema.GroupResource{Group: "stable.example.com", Resource: "foos"}
+	webhookURL := "Post https://webhook-service.default.svc:9443/crdconvert?timeout=30s"
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{errors.NewTooManyRequests("slow down", 1), "TooManyRequests (429)"},
+		{errors.NewTimeoutError("request timed out", 1), "Timeout (504)"},
+		{errors.NewConflict(foos, "foo", fmt.Errorf("modified")), "Conflict (409)"},
+		{errors.NewAlreadyExists(foos, "foo"), "AlreadyExists (409)"},
+		{errors.NewInternalError(fmt.Errorf("etcd is down")), "InternalError (500)"},
+		{errors.NewInternalError(fmt.Errorf("conversion webhook for stable.example.com/v2, Kind=Foo failed: %s: context deadline exceeded", webhookURL)), "ConversionWebhookTimeout (500)"},
+		{errors.NewInternalError(fmt.Errorf("conversion webhook for stable.example.com/v2, Kind=Foo failed: %s: dial tcp: connection refused", webhookURL)), "ConversionWebhookFailed (500)"},
+		{errors.NewInternalError(fmt.Errorf(`failed calling webhook "validate.foos-and-bars.stable.example.com": connection refused`)), "AdmissionWebhookFailed (500)"},
+		{errors.NewGenericServerResponse(502, "POST", foos, "", "bad gateway", 0, false), "InternalError (502)"},
+		{&url.Error{Op: "Post", URL: "https://127.0.0.1", Err: timeoutError{}}, "ClientTimeout"},
+		{fmt.Errorf("something else"), "Other"},
+	} {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Errorf("%v: got class %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestErrorStats(t *testing.T) {
+	s := newErrorStats()
+	s.record(nil)
+	s.record(nil)
+	s.record(errors.NewTooManyRequests("slow down", 1))
+	report := s.String()
+	for _, want := range []string{"errors: 1 of 3 requests (33.33%)", "TooManyRequests (429): 1", "0s: 1 of 3 (33.33%)"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report %q does not contain %q", report, want)
+		}
+	}
+
+	defer func(old bool) { *keepGoing = old }(*keepGoing)
+	*keepGoing = false
+	if err := s.check(fmt.Errorf("failed")); err == nil {
+		t.Errorf("got no error without --keep-going")
+	}
+	*keepGoing = true
+	if err := s.check(fmt.Errorf("failed")); err != nil {
+		t.Errorf("got error %v with --keep-going", err)
+	}
+}
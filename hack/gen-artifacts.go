@@ -0,0 +1,53 @@
+//go:build ignore
+// +build ignore
+
+// gen-artifacts writes artifacts.go, which holds the artifacts the setup
+// command applies, so that they have a single source in artifacts/ and the
+// binary doesn't need the repository at runtime. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// artifacts are the files in artifacts/ written to artifacts.go, by variable
+var artifacts = []struct {
+	name, file, doc string
+}{
+	{"fooCRD", "crd-template.yaml", "with the caBundle filled in by setup"},
+	{"barCRD", "bar-crd.yaml", ""},
+	{"webhookPod", "webhook-pod.yaml", "with the image filled in by setup"},
+	{"webhookService", "webhook-service.yaml", ""},
+}
+
+func main() {
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "// Code generated by hack/gen-artifacts.go from artifacts/; DO NOT EDIT.")
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, "package main")
+	for _, a := range artifacts {
+		data, err := ioutil.ReadFile(filepath.Join("artifacts", a.file))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if bytes.Contains(data, []byte("`")) {
+			fmt.Fprintf(os.Stderr, "artifacts/%s contains a backquote\n", a.file)
+			os.Exit(1)
+		}
+		doc := fmt.Sprintf("%s is artifacts/%s", a.name, a.file)
+		if a.doc != "" {
+			doc += ", " + a.doc
+		}
+		fmt.Fprintf(b, "\n// %s\nvar %s = []byte(`%s`)\n", doc, a.name, strings.TrimSuffix(string(data), "\n"))
+	}
+	if err := ioutil.WriteFile("artifacts.go", b.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stringList collects the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runLocal starts etcd, kube-apiserver and the webhook on this machine, sets
+// up the CRDs and namespaces, and runs the given command against them, e.g.
+//
+//	conversion-webhook-example local -- ./conversion-webhook-example.test -test.bench=CreateLatency
+//
+// Without a command it serves until interrupted. Commands find the apiserver
+// through the KUBECONFIG environment variable.
+func runLocal(args []string) {
+	fs := flag.NewFlagSet("local", flag.ExitOnError)
+	assets := fs.String("assets", defaultAssetsDir(), "directory with the etcd and kube-apiserver binaries, KUBEBUILDER_ASSETS by default")
+	dir := fs.String("dir", "", "directory for etcd data, certificates and the kubeconfig, a new temporary directory by default")
+	keep := fs.Bool("keep", false, "keep the directory after shutting down")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait for etcd, the apiserver and the CRDs to become ready")
+	var apiserverArgs stringList
+	fs.Var(&apiserverArgs, "apiserver-arg", "extra kube-apiserver flag, e.g. --apiserver-arg=--v=2, can be repeated")
+	fs.Parse(args)
+
+	if err := runLocalCluster(*assets, *dir, *keep, apiserverArgs, *timeout, fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runLocalCluster runs command against a local cluster, and stops the cluster
+// afterwards
+func runLocalCluster(assets, dir string, keep bool, apiserverArgs []string, timeout time.Duration, command []string) error {
+	if dir == "" {
+		d, err := ioutil.TempDir("", "conversion-webhook-example")
+		if err != nil {
+			return err
+		}
+		dir = d
+	}
+	if !keep {
+		defer os.RemoveAll(dir)
+	}
+
+	c, err := startLocalCluster(assets, dir, apiserverArgs, timeout)
+	if c != nil {
+		defer c.stop()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start local cluster, logs are in %s: %v", dir, err)
+	}
+	fmt.Printf("apiserver running, KUBECONFIG=%s\n", c.kubeconfig)
+
+	if len(command) == 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		return nil
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+c.kubeconfig)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v", command[0], err)
+	}
+	return nil
+}
+
+func defaultAssetsDir() string {
+	if dir := os.Getenv("KUBEBUILDER_ASSETS"); dir != "" {
+		return dir
+	}
+	return "/usr/local/kubebuilder/bin"
+}
+
+// localCluster is an etcd and kube-apiserver started by startLocalCluster
+type localCluster struct {
+	kubeconfig string
+	processes  []*exec.Cmd
+}
+
+func (c *localCluster) stop() {
+	// the apiserver goes first, it keeps retrying etcd otherwise
+	for i := len(c.processes) - 1; i >= 0; i-- {
+		cmd := c.processes[i]
+		cmd.Process.Signal(syscall.SIGTERM)
+		done := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+		}
+	}
+	c.processes = nil
+}
+
+// startLocalCluster starts etcd, kube-apiserver and the webhook, and installs
+// the CRDs. The returned cluster is non-nil if any process was started, so it
+// can be stopped on error.
+func startLocalCluster(assets, dir string, apiserverArgs []string, timeout time.Duration) (*localCluster, error) {
+	c := &localCluster{kubeconfig: filepath.Join(dir, "kubeconfig")}
+	ports, err := freePorts(4)
+	if err != nil {
+		return nil, err
+	}
+	etcdPort, peerPort, apiserverPort, webhookPort := ports[0], ports[1], ports[2], ports[3]
+
+	etcdURL := fmt.Sprintf("http://127.0.0.1:%d", etcdPort)
+	if err := c.start(dir, filepath.Join(assets, "etcd"),
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+etcdURL,
+		"--advertise-client-urls="+etcdURL,
+		fmt.Sprintf("--listen-peer-urls=http://127.0.0.1:%d", peerPort),
+	); err != nil {
+		return c, err
+	}
+	if err := waitForHealthz(etcdURL+"/health", "", timeout); err != nil {
+		return c, fmt.Errorf("etcd not ready: %v", err)
+	}
+
+	token, err := writeLocalCredentials(dir)
+	if err != nil {
+		return c, err
+	}
+	args := []string{
+		"--etcd-servers=" + etcdURL,
+		"--cert-dir=" + filepath.Join(dir, "apiserver"),
+		"--bind-address=127.0.0.1",
+		"--advertise-address=127.0.0.1",
+		fmt.Sprintf("--secure-port=%d", apiserverPort),
+		"--insecure-port=0",
+		"--token-auth-file=" + filepath.Join(dir, "tokens.csv"),
+		"--service-account-key-file=" + filepath.Join(dir, "service-account.pem"),
+		"--authorization-mode=AlwaysAllow",
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--endpoint-reconciler-type=none",
+		// there is no controller manager to create service account tokens
+		"--disable-admission-plugins=ServiceAccount",
+		"--feature-gates=CustomResourceWebhookConversion=true",
+		// webhooks are called at their endpoint addresses, there is no cluster DNS
+		"--enable-aggregator-routing=true",
+	}
+	if err := c.start(dir, filepath.Join(assets, "kube-apiserver"), append(args, apiserverArgs...)...); err != nil {
+		return c, err
+	}
+	server := fmt.Sprintf("https://127.0.0.1:%d", apiserverPort)
+	if err := waitForHealthz(server+"/healthz", token, timeout); err != nil {
+		return c, fmt.Errorf("kube-apiserver not ready: %v", err)
+	}
+	if err := writeKubeconfig(c.kubeconfig, server, token); err != nil {
+		return c, err
+	}
+	// the commands run by runLocal use KUBECONFIG, and so does setup in this process
+	if err := os.Setenv("KUBECONFIG", c.kubeconfig); err != nil {
+		return c, err
+	}
+
+	clientset, err := newClientset()
+	if err != nil {
+		return c, err
+	}
+	go createDefaultServiceAccounts(clientset)
+	if err := startLocalWebhook(clientset, dir, webhookPort, timeout); err != nil {
+		return c, err
+	}
+	if err := installCRDs(clientset, timeout); err != nil {
+		return c, err
+	}
+	for _, namespace := range []string{emptyNamespace, largeDataNamespace, largeMetadataNamespace} {
+		if err := setupNamespace(namespace); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// start runs binary with args, logging to a file in dir named after it
+func (c *localCluster) start(dir, binary string, args ...string) error {
+	log, err := os.Create(filepath.Join(dir, filepath.Base(binary)+".log"))
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout, cmd.Stderr = log, log
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", binary, err)
+	}
+	c.processes = append(c.processes, cmd)
+	return nil
+}
+
+// freePorts picks n free local ports
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+func waitForHealthz(url, token string, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: time.Second,
+		// the apiserver serves a self-signed certificate from its --cert-dir
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	return wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return false, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, nil
+	})
+}
+
+// writeLocalCredentials writes a token file with an admin token, and a key to
+// verify service account tokens with, which kube-apiserver requires
+func writeLocalCredentials(dir string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := ioutil.WriteFile(filepath.Join(dir, "tokens.csv"), []byte(token+",admin,admin,system:masters\n"), 0600); err != nil {
+		return "", err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	keyPEM := encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return token, ioutil.WriteFile(filepath.Join(dir, "service-account.pem"), keyPEM, 0600)
+}
+
+func writeKubeconfig(path, server, token string) error {
+	return ioutil.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+users:
+- name: admin
+  user:
+    token: %s
+contexts:
+- name: local
+  context:
+    cluster: local
+    user: admin
+current-context: local
+`, server, token)), 0600)
+}
+
+// createDefaultServiceAccounts creates the default service account of every
+// namespace, like the controller manager, so waitForNamespaceReady works
+func createDefaultServiceAccounts(clientset *kubernetes.Clientset) {
+	wait.Forever(func() {
+		namespaces, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+		if err != nil {
+			return
+		}
+		for _, ns := range namespaces.Items {
+			sa := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			_, err := clientset.CoreV1().ServiceAccounts(ns.Name).Create(sa)
+			if err != nil && !errors.IsAlreadyExists(err) {
+				fmt.Printf("failed to create default service account in %s: %v\n", ns.Name, err)
+			}
+		}
+	}, readyPollInterval)
+}
+
+// startLocalWebhook serves the webhook from this process, behind a service
+// without selector whose endpoint is this machine. Endpoint validation rejects
+// loopback and link-local addresses, so the webhook listens on a local network
+// address, also when the apiserver runs on this machine.
+func startLocalWebhook(clientset *kubernetes.Clientset, dir string, port int, timeout time.Duration) error {
+	ip, err := localIP()
+	if err != nil {
+		return err
+	}
+	certs, err := generateServingCerts(serviceHosts(webhookServiceName, webhookNamespace), 365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := storeServingCerts(clientset, webhookNamespace, webhookSecretName, certs); err != nil {
+		return err
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, certs.cert, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, certs.key, 0600); err != nil {
+		return err
+	}
+	go func() {
+		addr := net.JoinHostPort(ip.String(), fmt.Sprint(port))
+		if err := serveWebhook(addr, certFile, keyFile, 4, 10*time.Second, true); err != nil {
+			fmt.Printf("webhook stopped: %v\n", err)
+		}
+	}()
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: webhookServiceName},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{
+			{Name: "conversion", Protocol: v1.ProtocolTCP, Port: 9443, TargetPort: intstr.FromInt(port)},
+			{Name: "admission", Protocol: v1.ProtocolTCP, Port: 443, TargetPort: intstr.FromInt(port)},
+		}},
+	}
+	if err := applyLocalService(clientset, service); err != nil {
+		return err
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: webhookServiceName},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: ip.String()}},
+			Ports: []v1.EndpointPort{
+				{Name: "conversion", Protocol: v1.ProtocolTCP, Port: int32(port)},
+				{Name: "admission", Protocol: v1.ProtocolTCP, Port: int32(port)},
+			},
+		}},
+	}
+	if err := applyLocalEndpoints(clientset, endpoints); err != nil {
+		return err
+	}
+	return waitForEndpoints(clientset, webhookNamespace, webhookServiceName, timeout)
+}
+
+// applyLocalService creates service, or updates the ports of an existing one,
+// e.g. left by an earlier run with the same --dir. Its selector is dropped,
+// so that the endpoints controller leaves the local endpoints alone.
+func applyLocalService(clientset *kubernetes.Clientset, service *v1.Service) error {
+	services := clientset.CoreV1().Services(webhookNamespace)
+	existing, err := services.Get(service.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = services.Create(service)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if apiequality.Semantic.DeepEqual(existing.Spec.Ports, service.Spec.Ports) && len(existing.Spec.Selector) == 0 {
+		return nil
+	}
+	existing.Spec.Ports = service.Spec.Ports
+	existing.Spec.Selector = nil
+	_, err = services.Update(existing)
+	return err
+}
+
+// applyLocalEndpoints creates endpoints, or updates the subsets of existing
+// ones, e.g. with the address of this machine if it changed
+func applyLocalEndpoints(clientset *kubernetes.Clientset, endpoints *v1.Endpoints) error {
+	client := clientset.CoreV1().Endpoints(webhookNamespace)
+	existing, err := client.Get(endpoints.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(endpoints)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if apiequality.Semantic.DeepEqual(existing.Subsets, endpoints.Subsets) {
+		return nil
+	}
+	existing.Subsets = endpoints.Subsets
+	_, err = client.Update(existing)
+	return err
+}
+
+// localIP returns the first IPv4 address of this machine that isn't loopback
+// or link-local
+func localIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no network address to serve the webhook on: the apiserver rejects endpoints on loopback and link-local addresses, configure another address, e.g. on a dummy interface")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -10,65 +11,211 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 )
 
+// commands are the subcommands that run instead of a named tachymeter scenario
+var commands = map[string]func(args []string){
+	"certs":    runCerts,
+	"local":    runLocal,
+	"migrate":  runMigration,
+	"setup":    runSetup,
+	"strategy": runStrategy,
+	"webhook":  runWebhook,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	name := flag.String("name", "", "TODO: documentation")
 	run := flag.Int("run", 100, "TODO: documentation")
 	window := flag.Int("window", 50, "TODO: documentation")
+	rotateAt := flag.Int("rotate-certs-at", -1, "rotate the webhook serving certificate and CRD caBundle after this many requests, -1 to disable")
+	rotationSettle := flag.Duration("rotation-settle", 2*time.Minute, "how long to trust both the old and new CA while the webhook picks up the new certificate")
 	flag.Parse()
 	caller := *name
 	fmt.Println(caller)
+	fmt.Printf("run ID: %s\n", *runID)
+
+	if err := runScenario(caller, *run, *window, *rotateAt, *rotationSettle); err != nil {
+		if unsupported, ok := err.(*unsupportedError); ok {
+			fmt.Printf("%s skipped: %v\n", caller, unsupported)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", caller, err)
+		os.Exit(1)
+	}
+}
+
+// runScenario measures run requests of the named scenario with tachymeter,
+// and deletes the objects it created afterwards, also if it fails
+func runScenario(caller string, run, window, rotateAt int, rotationSettle time.Duration) (err error) {
+	if err := setupScenario(caller); err != nil {
+		if _, ok := err.(*unsupportedError); ok {
+			return err
+		}
+		return fmt.Errorf("failed to set up: %v", err)
+	}
 
-	// set up env
-	setupNamespace(emptyNamespace)
-	setupNamespace(largeDataNamespace)
-	setupNamespace(largeMetadataNamespace)
-	setupValidation(strings.Contains(caller, "Validation"))
+	template, err := getTemplate(caller)
+	if err != nil {
+		return err
+	}
+	size, err := describeTemplateSize(template)
+	if err != nil {
+		return err
+	}
+	fmt.Println(size)
+	warning, err := requestLimitWarning(template)
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		fmt.Println(warning)
+	}
 
-	var c BenchmarkClient
-	if strings.Contains(caller, "Typed") {
-		c = mustNewEndpointsBenchmarkClient(getNamespace(caller), getTemplate(caller), getListOptions(caller))
-	} else {
-		c = mustNewDynamicBenchmarkClient(getGVR(caller), getNamespace(caller), getTemplate(caller), getListOptions(caller))
+	c, err := newScenarioClient(caller, template)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	if err = checkLeftovers(c); err != nil {
+		return err
 	}
 
 	// always delete all objects created by current run, to avoid overwhelm etcd over time
 	defer func() {
 		start := time.Now()
-		if err := c.DeleteCollection(); err != nil {
-			if v, ok := err.(*errors.StatusError); ok {
-				panic(fmt.Errorf(v.DebugError()))
+		if cleanupErr := c.DeleteCollection(); cleanupErr != nil {
+			if v, ok := cleanupErr.(*errors.StatusError); ok {
+				cleanupErr = fmt.Errorf(v.DebugError())
+			}
+			cleanupErr = fmt.Errorf("failed to clean up objects: %v; time elapsed: %v", cleanupErr, time.Since(start))
+			if err == nil {
+				err = cleanupErr
+			} else {
+				fmt.Fprintln(os.Stderr, cleanupErr)
 			}
-			panic(fmt.Errorf("failed to clean up objects: %v; time elapsed: %v", err, time.Since(start)))
+			return
 		}
-		fmt.Println("objects cleaned up")
+		fmt.Printf("objects cleaned up in %v\n", time.Since(start))
 	}()
 
-	if strings.Contains(caller, "List") {
-		if err := ensureObjectCount(c, testListSize); err != nil {
-			panic(err)
+	if strings.Contains(caller, "List") || strings.Contains(caller, "DeleteCollection") {
+		if err := ensureObjectCount(c, getListSize(caller)); err != nil {
+			return err
 		}
 		fmt.Println("enough objects prepared")
 	}
 
-	// actual measurement
-	t := tachymeter.New(&tachymeter.Config{Size: *window})
+	// object updated by update scenarios
+	var updated interface{}
+	if strings.Contains(caller, "UpdateLatency") {
+		if updated, err = c.Create(0); err != nil {
+			return fmt.Errorf("failed to create object to update: %v", err)
+		}
+	}
 
-	var err error
-	for i := 0; i < *run; i++ {
+	metrics, err := startMetrics(caller, template)
+	if err != nil {
+		return err
+	}
+	profiles, err := startProfiles(caller)
+	if err != nil {
+		return err
+	}
+	// drop the traces and retries of requests setting up the scenario
+	requestTraces.reset()
+	if r, ok := c.(*retryingClient); ok {
+		r.reset()
+	}
+
+	// actual measurement
+	t := tachymeter.New(&tachymeter.Config{Size: window})
+	// requests sent while the webhook certificate is rotated
+	rotating := tachymeter.New(&tachymeter.Config{Size: run})
+	var rotation chan error
+	failures := 0
+	stats := newErrorStats()
+	for i := 0; i < run; i++ {
+		if i == rotateAt {
+			rotation = make(chan error, 1)
+			go func() {
+				rotation <- rotateServingCerts(rotationSettle)
+			}()
+		}
+		if strings.Contains(caller, "DeleteCollection") {
+			err := withoutMeasuring(c, func() error {
+				return ensureObjectCount(c, getListSize(caller))
+			})
+			if err != nil {
+				return err
+			}
+		}
 		start := time.Now()
 
 		// TODO: error on unsupported case
 		if strings.Contains(caller, "CreateLatency") {
 			_, err = c.Create(0)
+		} else if strings.Contains(caller, "UpdateLatency") {
+			var obj interface{}
+			if obj, err = c.Update(updated); err == nil {
+				updated = obj
+			}
 		} else if strings.Contains(caller, "List") {
 			_, err = c.List()
+		} else if strings.Contains(caller, "DeleteCollection") {
+			err = c.DeleteCollection()
 		}
+		stats.record(err)
 		if err != nil {
-			panic(err)
+			if rotation == nil && !*keepGoing {
+				return fmt.Errorf("request %d failed: %v", i, err)
+			}
+			if rotation != nil {
+				// keep going to see how many requests fail during rotation
+				failures++
+				fmt.Printf("request %d failed: %v\n", i, err)
+			}
+			continue
 		}
 
 		t.AddTime(time.Since(start))
+		if rotation != nil && len(rotation) == 0 {
+			rotating.AddTime(time.Since(start))
+		}
+	}
+	var deltas *metricsDelta
+	if metrics != nil {
+		if deltas, err = metrics.stop(); err != nil {
+			return err
+		}
+	}
+	if profiles != nil {
+		if err := profiles.stop(); err != nil {
+			return err
+		}
 	}
 
 	fmt.Println(t.Calc().String())
+	fmt.Print(stats)
+	if r, ok := c.(*retryingClient); ok {
+		fmt.Println(r)
+	}
+	if deltas != nil {
+		fmt.Print(deltas)
+	}
+	if *traceRequests {
+		fmt.Print(requestTraces)
+	}
+	if rotation != nil {
+		if err := <-rotation; err != nil {
+			return fmt.Errorf("failed to rotate certificates: %v", err)
+		}
+		fmt.Printf("during certificate rotation (%d requests failed since it started):\n", failures)
+		fmt.Println(rotating.Calc().String())
+	}
+	return nil
 }
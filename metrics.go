@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	scrapeMetrics = flag.Bool("apiserver-metrics", false, "scrape the apiserver /metrics before and after the measurement, and report the deltas of the scenario's resource and verbs")
+	resultsDir    = flag.String("results-dir", "", "directory to save metrics deltas and profiles of a scenario in, named after the scenario, profiles default to the current directory")
+
+	// metric families scraped from the apiserver, by name prefix. Names from
+	// before 1.14 are included for older clusters.
+	scrapedMetrics = []string{
+		"apiserver_request_duration_seconds",
+		"apiserver_request_total",
+		"apiserver_request_latencies",
+		"apiserver_request_count",
+		"apiserver_crd_webhook_conversion",
+		"apiserver_crd_conversion_webhook",
+		"apiserver_admission_webhook_admission_duration_seconds",
+		"etcd_request_duration_seconds",
+		"etcd_request_latencies_summary",
+		"apiserver_watch_events",
+		"apiserver_init_events_total",
+		"apiserver_cache_list",
+		"watch_cache_capacity",
+	}
+)
+
+// metricSample is one series of a scrape
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// metricSamples are the series of a scrape by their name and labels
+type metricSamples map[string]metricSample
+
+// metricsScope selects the series of a scenario's resource and verbs
+type metricsScope struct {
+	resource string
+	group    string
+	kind     string
+	// verbs and operations, uppercase, as in the verb and operation labels
+	verbs map[string]bool
+}
+
+// newMetricsScope selects the metrics of the resource the named scenario
+// creates from template, and of the requests it measures
+func newMetricsScope(name string, template []byte) (metricsScope, error) {
+	obj, err := decodeUnstructured(template)
+	if err != nil {
+		return metricsScope{}, err
+	}
+	gvr := getGVR(name)
+	if strings.Contains(name, "Typed") {
+		gvr = endpointsGVR
+	}
+	var verbs []string
+	switch {
+	case strings.Contains(name, "UpdateLatency"):
+		verbs = []string{"PUT", "UPDATE", "GET"}
+	case strings.Contains(name, "List"):
+		verbs = []string{"LIST"}
+	case strings.Contains(name, "Watch"):
+		verbs = []string{"WATCH", "POST", "CREATE"}
+	case strings.Contains(name, "DeleteCollection"):
+		verbs = []string{"DELETECOLLECTION", "DELETE", "LIST"}
+	default:
+		verbs = []string{"POST", "CREATE"}
+	}
+	scope := metricsScope{resource: gvr.Resource, group: gvr.Group, kind: obj.GetKind(), verbs: map[string]bool{}}
+	for _, verb := range verbs {
+		scope.verbs[verb] = true
+	}
+	return scope, nil
+}
+
+// matches tells whether a series is about the scope's resource and verbs.
+// Series without resource or verb labels, e.g. of our admission webhooks,
+// always match.
+func (s metricsScope) matches(labels map[string]string) bool {
+	for _, key := range []string{"verb", "operation"} {
+		if v, ok := labels[key]; ok && !s.verbs[strings.ToUpper(v)] {
+			return false
+		}
+	}
+	groupResource := s.resource
+	if s.group != "" {
+		groupResource += "." + s.group
+	}
+	if v, ok := labels["resource"]; ok && v != s.resource && v != groupResource {
+		return false
+	}
+	if v, ok := labels["resource_prefix"]; ok && !strings.HasSuffix(v, "/"+s.resource) {
+		return false
+	}
+	if v, ok := labels["crd_name"]; ok && v != groupResource {
+		return false
+	}
+	if v, ok := labels["kind"]; ok && v != s.kind && !strings.HasSuffix(v, "Kind="+s.kind) {
+		return false
+	}
+	if v, ok := labels["type"]; ok && strings.HasPrefix(v, "*") {
+		// etcd metrics are by the Go type of the stored objects, custom
+		// resources are stored as unstructured objects
+		want := "." + strings.ToLower(s.kind)
+		if s.group != "" {
+			want = "unstructured"
+		}
+		if !strings.Contains(strings.ToLower(v), want) {
+			return false
+		}
+	}
+	return true
+}
+
+// scrapeAPIServerMetrics reads the scrapedMetrics series from the apiserver
+func scrapeAPIServerMetrics() (metricSamples, error) {
+	clientset, err := newClientset()
+	if err != nil {
+		return nil, err
+	}
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath("/metrics").DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape apiserver metrics: %v", err)
+	}
+	return parseMetrics(data, scrapedMetrics)
+}
+
+// parseMetrics parses the series of the Prometheus text format whose names
+// start with one of prefixes
+func parseMetrics(data []byte, prefixes []string) (metricSamples, error) {
+	samples := metricSamples{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || !hasAnyPrefix(line, prefixes) {
+			continue
+		}
+		sample, key, err := parseMetricLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse metric %q: %v", line, err)
+		}
+		samples[key] = sample
+	}
+	return samples, scanner.Err()
+}
+
+// parseMetricLine parses `name{label="value",...} value [timestamp]`, and
+// returns the sample and its series key, the part before the value
+func parseMetricLine(line string) (metricSample, string, error) {
+	sample := metricSample{labels: map[string]string{}}
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 {
+		return sample, "", fmt.Errorf("no value")
+	}
+	sample.name = line[:end]
+	rest := line[end:]
+	if strings.HasPrefix(rest, "{") {
+		i := 1
+		for i < len(rest) && rest[i] != '}' {
+			eq := strings.IndexByte(rest[i:], '=')
+			if eq < 0 || i+eq+1 >= len(rest) || rest[i+eq+1] != '"' {
+				return sample, "", fmt.Errorf("malformed labels")
+			}
+			key := strings.TrimSpace(rest[i : i+eq])
+			// read the quoted value up to the closing unescaped quote
+			var value strings.Builder
+			j := i + eq + 2
+			for ; j < len(rest) && rest[j] != '"'; j++ {
+				if rest[j] == '\\' && j+1 < len(rest) {
+					j++
+					if rest[j] == 'n' {
+						value.WriteByte('\n')
+						continue
+					}
+				}
+				value.WriteByte(rest[j])
+			}
+			if j >= len(rest) {
+				return sample, "", fmt.Errorf("unterminated label value")
+			}
+			sample.labels[key] = value.String()
+			i = j + 1
+			if i < len(rest) && rest[i] == ',' {
+				i++
+			}
+		}
+		if i >= len(rest) {
+			return sample, "", fmt.Errorf("unterminated labels")
+		}
+		rest = rest[i+1:]
+	}
+	key := line[:len(line)-len(rest)]
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return sample, "", fmt.Errorf("no value")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, "", err
+	}
+	sample.value = value
+	return sample, key, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsDelta is the change of the series of a scope between two scrapes
+type metricsDelta struct {
+	keys   []string
+	deltas map[string]float64
+}
+
+// newMetricsDelta subtracts before from after for the series in scope that
+// changed. Quantiles of summaries can't be subtracted and are left out.
+func newMetricsDelta(before, after metricSamples, scope metricsScope) *metricsDelta {
+	d := &metricsDelta{deltas: map[string]float64{}}
+	for key, sample := range after {
+		if _, ok := sample.labels["quantile"]; ok || !scope.matches(sample.labels) {
+			continue
+		}
+		delta := sample.value - before[key].value
+		if delta == 0 {
+			continue
+		}
+		d.keys = append(d.keys, key)
+		d.deltas[key] = delta
+	}
+	sort.Strings(d.keys)
+	return d
+}
+
+// String reports the changed series without histogram buckets, and the mean
+// of every histogram or summary from its sum and count
+func (d *metricsDelta) String() string {
+	return d.format(false)
+}
+
+func (d *metricsDelta) format(buckets bool) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "apiserver metrics deltas:\n")
+	for _, key := range d.keys {
+		name := key
+		if i := strings.IndexByte(key, '{'); i >= 0 {
+			name = key[:i]
+		}
+		if !buckets && strings.HasSuffix(name, "_bucket") {
+			continue
+		}
+		fmt.Fprintf(b, "  %s %g", key, d.deltas[key])
+		if strings.HasSuffix(name, "_sum") {
+			countKey := strings.TrimSuffix(name, "_sum") + "_count" + key[len(name):]
+			if count := d.deltas[countKey]; count > 0 {
+				fmt.Fprintf(b, " (mean %g)", d.deltas[key]/count)
+			}
+		}
+		fmt.Fprintln(b)
+	}
+	return b.String()
+}
+
+// metricsRecorder scrapes the apiserver metrics at the start and end of a
+// measurement, if --apiserver-metrics is set
+type metricsRecorder struct {
+	scenario string
+	scope    metricsScope
+	before   metricSamples
+}
+
+// startMetrics takes the first scrape of a scenario, or returns nil if
+// metrics aren't scraped
+func startMetrics(name string, template []byte) (*metricsRecorder, error) {
+	if !*scrapeMetrics {
+		return nil, nil
+	}
+	scope, err := newMetricsScope(name, template)
+	if err != nil {
+		return nil, err
+	}
+	before, err := scrapeAPIServerMetrics()
+	if err != nil {
+		return nil, err
+	}
+	return &metricsRecorder{scenario: name, scope: scope, before: before}, nil
+}
+
+// stop takes the second scrape, saves all deltas to --results-dir if set, and
+// returns them
+func (r *metricsRecorder) stop() (*metricsDelta, error) {
+	after, err := scrapeAPIServerMetrics()
+	if err != nil {
+		return nil, err
+	}
+	d := newMetricsDelta(r.before, after, r.scope)
+	if *resultsDir != "" {
+		if err := saveResult(r.scenario, "metrics.txt", []byte(d.format(true))); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// saveResult writes data to --results-dir, or the current directory, in a file
+// named after the scenario and suffix
+func saveResult(scenario, suffix string, data []byte) error {
+	dir := *resultsDir
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, scenarioFileName(scenario)+"."+suffix), data, 0644)
+}
+
+// scenarioFileName is the name of a scenario without the package path of
+// benchmark functions
+func scenarioFileName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
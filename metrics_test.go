@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const metricsBefore = `# HELP apiserver_request_total Counter of apiserver requests
+# TYPE apiserver_request_total counter
+apiserver_request_total{code="201",resource="foos",verb="POST"} 10
+apiserver_request_total{code="200",resource="foos",verb="LIST"} 3
+apiserver_request_total{code="201",resource="bars",verb="POST"} 5
+apiserver_request_duration_seconds_bucket{resource="foos",verb="POST",le="0.1"} 8
+apiserver_request_duration_seconds_sum{resource="foos",verb="POST"} 0.5
+apiserver_request_duration_seconds_count{resource="foos",verb="POST"} 10
+apiserver_crd_webhook_conversion_duration_seconds_count{crd_name="foos.stable.example.com",from_version="stable.example.com/v2",to_version="stable.example.com/v1"} 4
+etcd_request_latencies_summary{operation="create",type="*unstructured.Unstructured",quantile="0.5"} 1000
+process_cpu_seconds_total 12
+`
+
+const metricsAfter = `apiserver_request_total{code="201",resource="foos",verb="POST"} 30
+apiserver_request_total{code="200",resource="foos",verb="LIST"} 4
+apiserver_request_total{code="201",resource="bars",verb="POST"} 9
+apiserver_request_duration_seconds_bucket{resource="foos",verb="POST",le="0.1"} 25
+apiserver_request_duration_seconds_sum{resource="foos",verb="POST"} 1.5
+apiserver_request_duration_seconds_count{resource="foos",verb="POST"} 30
+apiserver_crd_webhook_conversion_duration_seconds_count{crd_name="foos.stable.example.com",from_version="stable.example.com/v2",to_version="stable.example.com/v1"} 24
+apiserver_admission_webhook_admission_duration_seconds_count{name="validate.foos-and-bars.stable.example.com",operation="CREATE",rejected="false",type="validate"} 20
+etcd_request_latencies_summary{operation="create",type="*unstructured.Unstructured",quantile="0.5"} 2000
+process_cpu_seconds_total 20
+`
+
+func TestParseMetricLine(t *testing.T) {
+	sample, key, err := parseMetricLine(`apiserver_request_total{code="201",path="a\"b,c}",verb="POST"} 1.5e+03 1571234567`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `apiserver_request_total{code="201",path="a\"b,c}",verb="POST"}`; key != want {
+		t.Errorf("got key %s, want %s", key, want)
+	}
+	if sample.name != "apiserver_request_total" || sample.value != 1500 || sample.labels["path"] != `a"b,c}` || sample.labels["verb"] != "POST" {
+		t.Errorf("got sample %+v", sample)
+	}
+	if _, _, err := parseMetricLine(`apiserver_request_total{code="201} 1`); err == nil {
+		t.Errorf("got no error for unterminated labels")
+	}
+}
+
+func TestMetricsDelta(t *testing.T) {
+	before, err := parseMetrics([]byte(metricsBefore), scrapedMetrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := parseMetrics([]byte(metricsAfter), scrapedMetrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := []byte(`{"apiVersion":"stable.example.com/v1","kind":"Foo"}`)
+	scope, err := newMetricsScope("Benchmark_CreateLatency_CRWithConvert", template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newMetricsDelta(before, after, scope)
+	want := map[string]float64{
+		`apiserver_request_total{code="201",resource="foos",verb="POST"}`:                                                                                                     20,
+		`apiserver_request_duration_seconds_bucket{resource="foos",verb="POST",le="0.1"}`:                                                                                     17,
+		`apiserver_request_duration_seconds_sum{resource="foos",verb="POST"}`:                                                                                                 1,
+		`apiserver_request_duration_seconds_count{resource="foos",verb="POST"}`:                                                                                               20,
+		`apiserver_crd_webhook_conversion_duration_seconds_count{crd_name="foos.stable.example.com",from_version="stable.example.com/v2",to_version="stable.example.com/v1"}`: 20,
+		`apiserver_admission_webhook_admission_duration_seconds_count{name="validate.foos-and-bars.stable.example.com",operation="CREATE",rejected="false",type="validate"}`:  20,
+	}
+	if len(d.keys) != len(want) {
+		t.Errorf("got deltas %v, want %v", d.deltas, want)
+	}
+	for key, value := range want {
+		if d.deltas[key] != value {
+			t.Errorf("%s: got delta %g, want %g", key, d.deltas[key], value)
+		}
+	}
+
+	report := d.String()
+	if strings.Contains(report, "_bucket") {
+		t.Errorf("report %q contains histogram buckets", report)
+	}
+	if want := `apiserver_request_duration_seconds_sum{resource="foos",verb="POST"} 1 (mean 0.05)`; !strings.Contains(report, want) {
+		t.Errorf("report %q does not contain %q", report, want)
+	}
+	if !strings.Contains(d.format(true), "_bucket") {
+		t.Errorf("saved deltas don't contain histogram buckets")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamiealquiza/tachymeter"
+	clientv1beta1 "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// migrationResult summarizes a storage version migration run
+type migrationResult struct {
+	objects  int
+	pages    int
+	listTime time.Duration
+	total    time.Duration
+	// conversions is the number of objects sent through the converter: every
+	// object is converted once when listed and once more when the apiserver
+	// reads the old stored copy during the update
+	conversions int64
+	conflicts   int64
+	updates     *tachymeter.Metrics
+}
+
+func (r *migrationResult) String() string {
+	return fmt.Sprintf(`migrated %d objects in %v (%.2f objects/s)
+listed %d pages in %v
+estimated webhook conversions: %d (%.2f/s)
+update conflicts retried: %d
+update latency:
+%s`, r.objects, r.total, float64(r.objects)/r.total.Seconds(),
+		r.pages, r.listTime,
+		r.conversions, float64(r.conversions)/r.total.Seconds(),
+		r.conflicts,
+		r.updates.String())
+}
+
+// runMigration fills a namespace with Foo objects stored at v2, flips the
+// storage version to v1 and rewrites every object with a no-op update, the same
+// way the storage version migrator does
+func runMigration(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	count := fs.Int("count", 1000, "number of Foo objects to create at the old storage version")
+	concurrency := fs.Int("concurrency", 10, "number of concurrent no-op updates")
+	pageSize := fs.Int64("page-size", 500, "number of objects to list per page while migrating")
+	fs.BoolVar(purgeLeftovers, "purge-leftovers", false, "delete objects left in the migration namespace by earlier runs before migrating")
+	fs.BoolVar(purgeUnlabeled, "purge-unlabeled", false, "delete objects without a run ID label in the migration namespace, left by runs from before objects were labeled, before migrating")
+	fs.DurationVar(namespaceReadyTimeout, "namespace-ready-timeout", *namespaceReadyTimeout, "how long to wait for the migration namespace to become usable")
+	fs.DurationVar(crdReadyTimeout, "crd-ready-timeout", *crdReadyTimeout, "how long to wait for a storage version change to take effect")
+	fs.Parse(args)
+
+	if err := migrate(*count, *concurrency, *pageSize); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// migrate runs the migration of count objects, and deletes them and restores
+// the storage version afterwards, also if it fails
+func migrate(count, concurrency int, pageSize int64) (err error) {
+	if err := setupNamespace(migrationNamespace); err != nil {
+		return err
+	}
+	crdClient, err := newCRDClient()
+	if err != nil {
+		return err
+	}
+	if err := ensureStorageVersion(fooName, "v2"); err != nil {
+		return err
+	}
+
+	config, err := newRESTConfig()
+	if err != nil {
+		return err
+	}
+	c, err := newDynamicBenchmarkClient(config, foov2GVR, migrationNamespace, foov2Template, &metav1.ListOptions{}, nil)
+	if err != nil {
+		return err
+	}
+	if err := checkLeftovers(c); err != nil {
+		return err
+	}
+	defer func() {
+		cleanupErr := c.DeleteCollection()
+		if cleanupErr != nil {
+			cleanupErr = fmt.Errorf("failed to clean up objects: %v", cleanupErr)
+		} else {
+			fmt.Println("objects cleaned up")
+			// restore the storage version from crd-template.yaml for other benchmarks
+			cleanupErr = ensureStorageVersion(fooName, "v2")
+		}
+		if cleanupErr == nil {
+			return
+		}
+		if err == nil {
+			err = cleanupErr
+		} else {
+			fmt.Fprintln(os.Stderr, cleanupErr)
+		}
+	}()
+
+	if err := ensureObjectCount(c, count); err != nil {
+		return err
+	}
+	fmt.Printf("%d objects stored at v2\n", count)
+
+	if err := ensureStorageVersion(fooName, "v1"); err != nil {
+		return err
+	}
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		return err
+	}
+	result, err := migrateObjects(dynamicClient.Resource(foov1GVR).Namespace(migrationNamespace), pageSize, concurrency)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result.String())
+
+	return pruneStoredVersions(crdClient, fooName)
+}
+
+// migrateObjects lists all objects page by page and rewrites each of them at
+// the current storage version
+func migrateObjects(client dynamic.ResourceInterface, pageSize int64, concurrency int) (*migrationResult, error) {
+	t := tachymeter.New(&tachymeter.Config{Size: 100000, Safe: true})
+	result := &migrationResult{}
+	items := make(chan unstructured.Unstructured)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				start := time.Now()
+				if err := noopUpdate(client, &item, &result.conflicts); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				t.AddTime(time.Since(start))
+				atomic.AddInt64(&result.conversions, 1)
+			}
+		}()
+	}
+
+	start := time.Now()
+	opts := metav1.ListOptions{Limit: pageSize}
+	for {
+		listStart := time.Now()
+		l, err := client.List(opts)
+		if err != nil {
+			close(items)
+			wg.Wait()
+			return nil, fmt.Errorf("failed to list objects to migrate: %v", err)
+		}
+		result.listTime += time.Since(listStart)
+		result.pages++
+		result.objects += len(l.Items)
+		atomic.AddInt64(&result.conversions, int64(len(l.Items)))
+		for _, item := range l.Items {
+			items <- item
+		}
+		opts.Continue = l.GetContinue()
+		if opts.Continue == "" {
+			break
+		}
+	}
+	close(items)
+	wg.Wait()
+	result.total = time.Since(start)
+	result.updates = t.Calc()
+	return result, firstErr
+}
+
+// noopUpdate writes obj back unchanged, refreshing it on conflict
+func noopUpdate(client dynamic.ResourceInterface, obj *unstructured.Unstructured, conflicts *int64) error {
+	name := obj.GetName()
+	for {
+		_, err := client.Update(obj, metav1.UpdateOptions{})
+		if err == nil {
+			return nil
+		}
+		if !errors.IsConflict(err) {
+			return fmt.Errorf("failed to update %s: %v", name, err)
+		}
+		atomic.AddInt64(conflicts, 1)
+		if obj, err = client.Get(name, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("failed to refresh %s: %v", name, err)
+		}
+	}
+}
+
+// pruneStoredVersions drops every version but the storage version from
+// status.storedVersions and confirms the apiserver accepted it
+func pruneStoredVersions(client clientv1beta1.CustomResourceDefinitionInterface, name string) error {
+	crd, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	storage := ""
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			storage = v.Name
+		}
+	}
+	fmt.Printf("status.storedVersions before pruning: %v\n", crd.Status.StoredVersions)
+	crd.Status.StoredVersions = []string{storage}
+	if _, err := client.UpdateStatus(crd); err != nil {
+		return fmt.Errorf("failed to prune stored versions: %v", err)
+	}
+	crd, err = client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if len(crd.Status.StoredVersions) != 1 || crd.Status.StoredVersions[0] != storage {
+		return fmt.Errorf("stored versions not pruned, got %v", crd.Status.StoredVersions)
+	}
+	fmt.Printf("status.storedVersions pruned to: %v\n", crd.Status.StoredVersions)
+	return nil
+}
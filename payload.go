@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// e.g. Benchmark_List_CR_PayloadObjectArray fills spec.payload with an array
+	// of objects, up to largeDataSize kB
+	payloadShapeRegexp = regexp.MustCompile(`Payload([A-Z][A-Za-z]*)`)
+
+	// fields under spec that hold the generated payload
+	payloadFields = []string{"spec", "payload"}
+	// payloads are generated from a fixed seed, so every run gets the same objects
+	payloadSeed int64 = 1
+	// depth of the objects added by the Nested shape
+	payloadNestingDepth = 10
+
+	// e.g. Benchmark_List_CR_Size100KB pads objects to 100kB, a sweep over the
+	// size axis gives latency-versus-object-size curves
+	sizeScenarioRegexp = regexp.MustCompile(`Size(\d+)(KB|MB)`)
+	// default --max-request-bytes of etcd, the apiserver can't store larger objects
+	etcdRequestLimit = 1536 * 1024
+	// objects above this share of etcdRequestLimit get a warning
+	requestLimitWarningRatio = 0.5
+	// lists in size scenarios are capped to this many bytes, instead of
+	// testListSize objects
+	sizeScenarioListBytes = 100 * 1000 * 1000
+)
+
+// payloadShape adds the i-th chunk of a payload, drawing values from r
+type payloadShape func(payload map[string]interface{}, i int, r *rand.Rand)
+
+var payloadShapes = map[string]payloadShape{
+	"SmallFields": smallFieldsShape,
+	"Nested":      nestedShape,
+	"ObjectArray": objectArrayShape,
+	"Numeric":     numericShape,
+	"Random":      randomShape,
+}
+
+// getPayloadShape returns the payload shape of a scenario, or "" if it uses none
+func getPayloadShape(name string) string {
+	m := payloadShapeRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func smallFieldsShape(payload map[string]interface{}, i int, r *rand.Rand) {
+	payload[fmt.Sprintf("f%05d", i)] = fmt.Sprintf("v%d", r.Intn(1000))
+}
+
+func nestedShape(payload map[string]interface{}, i int, r *rand.Rand) {
+	var value interface{} = r.Int63()
+	for d := 0; d < payloadNestingDepth; d++ {
+		value = map[string]interface{}{fmt.Sprintf("level%d", d): value}
+	}
+	payload[fmt.Sprintf("n%05d", i)] = value
+}
+
+func objectArrayShape(payload map[string]interface{}, i int, r *rand.Rand) {
+	items, _ := payload["items"].([]interface{})
+	payload["items"] = append(items, map[string]interface{}{
+		"name":  fmt.Sprintf("item-%d", i),
+		"ip":    fmt.Sprintf("10.%d.%d.%d", r.Intn(256), r.Intn(256), r.Intn(256)),
+		"port":  int64(1024 + r.Intn(64511)),
+		"ready": r.Intn(2) == 0,
+		"labels": map[string]interface{}{
+			"app":  fmt.Sprintf("app-%d", r.Intn(100)),
+			"zone": fmt.Sprintf("zone-%d", r.Intn(3)),
+		},
+	})
+}
+
+func numericShape(payload map[string]interface{}, i int, r *rand.Rand) {
+	numbers, _ := payload["numbers"].([]interface{})
+	for j := 0; j < 8; j++ {
+		numbers = append(numbers, r.Int63())
+		numbers = append(numbers, r.NormFloat64())
+	}
+	payload["numbers"] = numbers
+}
+
+func randomShape(payload map[string]interface{}, i int, r *rand.Rand) {
+	b := make([]byte, 48)
+	r.Read(b)
+	payload[fmt.Sprintf("r%05d", i)] = fmt.Sprintf("%x", b)
+}
+
+// generatePayload fills spec.payload of an object template with the given
+// shape, until the object is at least size bytes as JSON
+func generatePayload(data []byte, shapeName string, size int) ([]byte, error) {
+	shape, ok := payloadShapes[shapeName]
+	if !ok {
+		return nil, fmt.Errorf("unknown payload shape %q", shapeName)
+	}
+	u := unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	if u.GetKind() == "Endpoints" {
+		return nil, fmt.Errorf("payload shapes need a spec, Endpoints only support LargeData and LargeMetadata")
+	}
+	// SetNestedField copies its value, so the payload is looked up again to be
+	// filled in place
+	if err := unstructured.SetNestedField(u.Object, map[string]interface{}{}, payloadFields...); err != nil {
+		return nil, err
+	}
+	value, _, _ := unstructured.NestedFieldNoCopy(u.Object, payloadFields...)
+	payload := value.(map[string]interface{})
+	r := rand.New(rand.NewSource(payloadSeed))
+	if _, err := fillToSize(&u, size, func(i int) {
+		shape(payload, i, r)
+	}); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&u)
+}
+
+// fillToSize calls add with increasing i until u is at least size bytes as
+// JSON, and returns the final size. The size is measured after every batch of
+// additions, each half of the estimated remainder, so it overshoots by at most
+// a few chunks.
+func fillToSize(u *unstructured.Unstructured, size int, add func(i int)) (int, error) {
+	current, err := marshaledSize(u)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; current < size; {
+		add(i)
+		i++
+		next, err := marshaledSize(u)
+		if err != nil {
+			return 0, err
+		}
+		chunk := next - current
+		if chunk < 1 {
+			chunk = 1
+		}
+		current = next
+		for n := (size - current) / chunk / 2; n > 0; n-- {
+			add(i)
+			i++
+		}
+		if current, err = marshaledSize(u); err != nil {
+			return 0, err
+		}
+	}
+	return current, nil
+}
+
+// marshaledSize is the size of u as JSON
+func marshaledSize(u *unstructured.Unstructured) (int, error) {
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// templateSize measures an object template as sent to the apiserver, as JSON
+// and, for Endpoints, as protobuf. Custom resources are only served as JSON,
+// so their protobuf size is 0.
+func templateSize(data []byte) (jsonSize, protobufSize int, err error) {
+	u := unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u); err != nil {
+		return 0, 0, err
+	}
+	if jsonSize, err = marshaledSize(&u); err != nil {
+		return 0, 0, err
+	}
+	if u.GetKind() != "Endpoints" {
+		return jsonSize, 0, nil
+	}
+	e := &v1.Endpoints{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, e); err != nil {
+		return 0, 0, err
+	}
+	buf := bytes.Buffer{}
+	if err := protobuf.NewSerializer(scheme.Scheme, scheme.Scheme, "application/vnd.kubernetes.protobuf").Encode(e, &buf); err != nil {
+		return 0, 0, err
+	}
+	return jsonSize, buf.Len(), nil
+}
+
+// describeTemplateSize formats the measured size of an object template
+func describeTemplateSize(data []byte) (string, error) {
+	jsonSize, protobufSize, err := templateSize(data)
+	if err != nil {
+		return "", err
+	}
+	if protobufSize == 0 {
+		return fmt.Sprintf("object size: %d bytes as JSON", jsonSize), nil
+	}
+	return fmt.Sprintf("object size: %d bytes as JSON, %d bytes as protobuf", jsonSize, protobufSize), nil
+}
+
+// sizeScenario parses the object size in kB from a benchmark name
+func sizeScenario(name string) (size int, ok bool) {
+	m := sizeScenarioRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	size, _ = strconv.Atoi(m[1])
+	if m[2] == "MB" {
+		size *= 1000
+	}
+	return size, true
+}
+
+// getListSize returns the number of objects to list, fewer for large objects
+// in size scenarios so a list stays within sizeScenarioListBytes
+func getListSize(name string) int {
+	size, ok := sizeScenario(name)
+	if !ok || size*1000*testListSize <= sizeScenarioListBytes {
+		return testListSize
+	}
+	return sizeScenarioListBytes / (size * 1000)
+}
+
+// addEndpointsAddresses pads an Endpoints template with addresses until it is
+// at least size kB as JSON. Unlike annotations, which are limited to 256kB in
+// total, addresses can grow up to the request size limit.
+func addEndpointsAddresses(data []byte, size int) ([]byte, error) {
+	u := unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	subset := map[string]interface{}{
+		"ports": []interface{}{map[string]interface{}{"name": "http", "port": int64(8080), "protocol": "TCP"}},
+	}
+	u.Object["subsets"] = []interface{}{subset}
+	var addresses []interface{}
+	_, err := fillToSize(&u, size*1000, func(i int) {
+		addresses = append(addresses, map[string]interface{}{
+			"ip":       fmt.Sprintf("10.%d.%d.%d", (i>>16)&255, (i>>8)&255, i&255),
+			"nodeName": fmt.Sprintf("node-%d", i%5000),
+		})
+		subset["addresses"] = addresses
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&u)
+}
+
+// requestLimitWarning warns about objects close to etcdRequestLimit, or
+// returns "" for smaller ones
+func requestLimitWarning(data []byte) (string, error) {
+	jsonSize, protobufSize, err := templateSize(data)
+	if err != nil {
+		return "", err
+	}
+	// Endpoints are stored as protobuf, custom resources as JSON
+	stored := jsonSize
+	if protobufSize != 0 {
+		stored = protobufSize
+	}
+	if float64(stored) < requestLimitWarningRatio*float64(etcdRequestLimit) {
+		return "", nil
+	}
+	return fmt.Sprintf("WARNING: objects are %d bytes, %d%% of the %d byte etcd request limit, so updates that grow them may be rejected",
+		stored, stored*100/etcdRequestLimit, etcdRequestLimit), nil
+}
+
+// sizeNamespace keeps objects of every size scenario apart, so a list only
+// counts objects of one size
+func sizeNamespace(size int) string {
+	return fmt.Sprintf("size-%dkb", size)
+}
+
+// payloadNamespace keeps objects of every payload shape and size apart
+func payloadNamespace(shape string, size int) string {
+	return fmt.Sprintf("payload-%s-%dkb", strings.ToLower(shape), size)
+}
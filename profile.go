@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+var (
+	captureProfiles = flag.Bool("profile", false, "capture CPU, heap and goroutine profiles of the apiserver and the webhook during the measurement, and save them to --results-dir")
+	profileDuration = flag.Duration("profile-duration", 30*time.Second, "length of the CPU profiles, which start with the measurement")
+)
+
+// profileSource is a server pprof profiles are captured from. get returns the
+// response to a request of path with the given query parameters.
+type profileSource struct {
+	name string
+	get  func(path string, params map[string]string) ([]byte, error)
+}
+
+// newProfileSources returns the apiserver, and the webhook through the
+// apiserver's service proxy, which also reaches webhooks in the cluster
+func newProfileSources() ([]profileSource, error) {
+	clientset, err := newClientset()
+	if err != nil {
+		return nil, err
+	}
+	apiserver := func(path string, params map[string]string) ([]byte, error) {
+		req := clientset.CoreV1().RESTClient().Get().AbsPath(path)
+		for key, value := range params {
+			req = req.Param(key, value)
+		}
+		return req.DoRaw()
+	}
+	webhook := func(path string, params map[string]string) ([]byte, error) {
+		data, err := clientset.CoreV1().Services(webhookNamespace).ProxyGet("https", webhookServiceName, "admission", path, params).DoRaw()
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("%v, the webhook only serves profiles with --profiling, see setup --webhook-profiling", err)
+		}
+		return data, err
+	}
+	return []profileSource{{"apiserver", apiserver}, {"webhook", webhook}}, nil
+}
+
+// profiler captures the profiles of a scenario, if --profile is set. The CPU
+// profiles cover --profile-duration from the start of the measurement, heap
+// and goroutine profiles are captured at its end.
+type profiler struct {
+	scenario string
+	sources  []profileSource
+	// results of the CPU profiles, one per source
+	cpu chan error
+}
+
+// startProfiles starts the CPU profiles of a scenario, or returns nil if
+// profiles aren't captured
+func startProfiles(name string) (*profiler, error) {
+	if !*captureProfiles {
+		return nil, nil
+	}
+	sources, err := newProfileSources()
+	if err != nil {
+		return nil, err
+	}
+	seconds := int(profileDuration.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	p := &profiler{scenario: name, sources: sources, cpu: make(chan error, len(sources))}
+	for _, source := range sources {
+		source := source
+		go func() {
+			p.cpu <- p.capture(source, "cpu", "/debug/pprof/profile", map[string]string{"seconds": strconv.Itoa(seconds)})
+		}()
+	}
+	return p, nil
+}
+
+// capture saves a profile of source as <scenario>.<source>.<kind>.pprof
+func (p *profiler) capture(source profileSource, kind, path string, params map[string]string) error {
+	data, err := source.get(path, params)
+	if err != nil {
+		return fmt.Errorf("failed to capture %s %s profile: %v", source.name, kind, err)
+	}
+	return saveResult(p.scenario, fmt.Sprintf("%s.%s.pprof", source.name, kind), data)
+}
+
+// stop captures the heap and goroutine profiles, and waits for the CPU
+// profiles to finish
+func (p *profiler) stop() error {
+	var errs []error
+	for _, source := range p.sources {
+		for _, kind := range []string{"heap", "goroutine"} {
+			if err := p.capture(source, kind, "/debug/pprof/"+kind, nil); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for range p.sources {
+		if err := <-p.cpu; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { *resultsDir = old }(*resultsDir)
+	*resultsDir = dir
+
+	serving := profileSource{"apiserver", func(path string, params map[string]string) ([]byte, error) {
+		return []byte(path + fmt.Sprint(params)), nil
+	}}
+	failing := profileSource{"webhook", func(path string, params map[string]string) ([]byte, error) {
+		return nil, fmt.Errorf("service unavailable")
+	}}
+	p := &profiler{scenario: "github.com/jpbetz/conversion-webhook-example.Benchmark_CreateLatency_CRWithConvert", sources: []profileSource{serving, failing}, cpu: make(chan error, 2)}
+	for _, source := range p.sources {
+		p.cpu <- p.capture(source, "cpu", "/debug/pprof/profile", map[string]string{"seconds": "1"})
+	}
+	err = p.stop()
+	if err == nil || !strings.Contains(err.Error(), "failed to capture webhook heap profile") {
+		t.Errorf("got error %v, want the failed webhook profiles", err)
+	}
+
+	for file, want := range map[string]string{
+		"Benchmark_CreateLatency_CRWithConvert.apiserver.cpu.pprof":       "/debug/pprof/profilemap[seconds:1]",
+		"Benchmark_CreateLatency_CRWithConvert.apiserver.heap.pprof":      "/debug/pprof/heapmap[]",
+		"Benchmark_CreateLatency_CRWithConvert.apiserver.goroutine.pprof": "/debug/pprof/goroutinemap[]",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", file, data, want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	clientv1beta1 "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	namespaceReadyTimeout = flag.Duration("namespace-ready-timeout", time.Minute, "how long to wait for a new namespace to become usable")
+	crdReadyTimeout       = flag.Duration("crd-ready-timeout", time.Minute, "how long to wait for a CRD update to take effect")
+
+	readyPollInterval = 200 * time.Millisecond
+)
+
+// conversionProbe is a Foo v1 whose hostPort can't be split into the host and
+// port of v2, so creating it fails when it has to be converted to v2 for
+// storage, with convertFoo as with the e2e crd-conversion-webhook image. The
+// kind is required to decode it, waitForProbe sets the kind of the CRD probed.
+var conversionProbe = []byte(`apiVersion: stable.example.com/v1
+kind: Foo
+metadata:
+  name: conversion-probe
+hostPort: conversion-probe`)
+
+// waitForNamespaceReady waits until given namespace is active and its default
+// service account exists, so objects can be created in it
+func waitForNamespaceReady(clientset *kubernetes.Clientset, name string, timeout time.Duration) error {
+	err := wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+		ns, err := clientset.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if ns.Status.Phase != v1.NamespaceActive {
+			return false, nil
+		}
+		_, err = clientset.CoreV1().ServiceAccounts(name).Get("default", metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("namespace %s not ready: %v", name, err)
+	}
+	return nil
+}
+
+// waitForCRDReady waits until given CRD has accepted names and is established.
+// Both stay true across updates and CRD status has no observed generation, so
+// this only tells that a new CRD is served. Callers that need to know an update
+// is live have to probe for it instead, see waitForValidation,
+// waitForConversion and waitForPruning.
+func waitForCRDReady(client clientv1beta1.CustomResourceDefinitionInterface, name string, timeout time.Duration) error {
+	err := wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+		crd, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return hasCRDCondition(crd, v1beta1.Established) && hasCRDCondition(crd, v1beta1.NamesAccepted), nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s not ready: %v", name, err)
+	}
+	return nil
+}
+
+func hasCRDCondition(crd *v1beta1.CustomResourceDefinition, conditionType v1beta1.CustomResourceDefinitionConditionType) bool {
+	for _, c := range crd.Status.Conditions {
+		if c.Type == conditionType {
+			return c.Status == v1beta1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// waitForProbe dry-run creates probe as given version of crd until done tells
+// the outcome is the expected one, or fails
+func waitForProbe(crd *v1beta1.CustomResourceDefinition, version string, probe *unstructured.Unstructured, timeout time.Duration,
+	done func(obj *unstructured.Unstructured, err error) (bool, error)) error {
+	gvr := schema.GroupVersionResource{Group: crd.Spec.Group, Version: version, Resource: crd.Spec.Names.Plural}
+	probe.SetAPIVersion(gvr.GroupVersion().String())
+	probe.SetKind(crd.Spec.Names.Kind)
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		return err
+	}
+	client := dynamicClient.Resource(gvr).Namespace(emptyNamespace)
+
+	return wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+		obj, err := client.Create(probe, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+		if errors.IsNotFound(err) {
+			// the resource is not served yet
+			return false, nil
+		}
+		return done(obj, err)
+	})
+}
+
+// storageVersion is the version crd stores objects at
+func storageVersion(crd *v1beta1.CustomResourceDefinition) string {
+	version := crd.Spec.Version
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			version = v.Name
+		}
+	}
+	return version
+}
+
+// typedProperty is a property whose type a schema constrains
+type typedProperty struct {
+	path       []string
+	schemaType string
+}
+
+// typedProperties lists the properties of schema with a type, depth first in
+// the order of their names
+func typedProperties(schema *v1beta1.JSONSchemaProps, path []string) []typedProperty {
+	var properties []typedProperty
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property := schema.Properties[name]
+		propertyPath := append(append([]string{}, path...), name)
+		if property.Type != "" {
+			properties = append(properties, typedProperty{path: propertyPath, schemaType: property.Type})
+		}
+		properties = append(properties, typedProperties(&property, propertyPath)...)
+	}
+	return properties
+}
+
+// wrongTypeValue is a value that a property of schemaType rejects
+func wrongTypeValue(schemaType string) interface{} {
+	if schemaType == "string" {
+		return int64(1)
+	}
+	return "validation-probe"
+}
+
+// mayAccept tells whether schema may accept an object with only value at path,
+// judging by the types and required properties along the path
+func mayAccept(schema *v1beta1.JSONSchemaProps, path []string, value interface{}) bool {
+	for _, name := range path {
+		if schema == nil {
+			return true
+		}
+		if (schema.Type != "" && schema.Type != "object") || len(schema.Required) > 0 {
+			return false
+		}
+		property, ok := schema.Properties[name]
+		if !ok {
+			return true
+		}
+		schema = &property
+	}
+	switch value.(type) {
+	case string:
+		return schema.Type == "" || schema.Type == "string"
+	default:
+		return schema.Type == "" || schema.Type == "integer" || schema.Type == "number"
+	}
+}
+
+// validationProbe returns an object with a property of the wrong type, which
+// validation rejects, preferring a property whose wrong value previous accepts,
+// so that creating it tells which of the two schemas the apiserver enforces.
+// It returns nil if validation constrains the type of no property.
+func validationProbe(validation, previous *v1beta1.CustomResourceValidation) *unstructured.Unstructured {
+	if validation == nil || validation.OpenAPIV3Schema == nil {
+		return nil
+	}
+	var previousSchema *v1beta1.JSONSchemaProps
+	if previous != nil {
+		previousSchema = previous.OpenAPIV3Schema
+	}
+	var probe *unstructured.Unstructured
+	for _, property := range typedProperties(validation.OpenAPIV3Schema, nil) {
+		value := wrongTypeValue(property.schemaType)
+		u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		if err := unstructured.SetNestedField(u.Object, value, property.path...); err != nil {
+			continue
+		}
+		u.SetName("validation-probe")
+		if mayAccept(previousSchema, property.path, value) {
+			return u
+		}
+		if probe == nil {
+			probe = u
+		}
+	}
+	return probe
+}
+
+// defaultingProbe returns an object with an empty object for every top level
+// object property of schema, whose defaults the apiserver fills in if schema is
+// enforced
+func defaultingProbe(schema *v1beta1.JSONSchemaProps) *unstructured.Unstructured {
+	probe := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if schema != nil {
+		for name, property := range schema.Properties {
+			if property.Type == "object" && property.Default == nil {
+				probe.Object[name] = map[string]interface{}{}
+			}
+		}
+	}
+	probe.SetName("defaulting-probe")
+	return probe
+}
+
+// waitForValidation dry-run creates probes until the apiserver enforces the
+// validation of crd rather than previous, without changing either schema: an
+// object with a property of the wrong type for the new schema has to be
+// rejected, or one for the previous schema accepted if crd has no validation,
+// and if only one of them has defaults, empty objects have to be defaulted or
+// not. If no such probe tells the schemas apart, e.g. if they only differ in
+// their patterns, the probes may pass before the new schema is live.
+func waitForValidation(crd *v1beta1.CustomResourceDefinition, previous *v1beta1.CustomResourceValidation, timeout time.Duration) error {
+	var err error
+	if probe := validationProbe(crd.Spec.Validation, previous); probe != nil {
+		err = waitForProbe(crd, storageVersion(crd), probe, timeout, func(_ *unstructured.Unstructured, err error) (bool, error) {
+			if errors.IsInvalid(err) {
+				return true, nil
+			}
+			return false, err
+		})
+	} else if probe := validationProbe(previous, nil); probe != nil && crd.Spec.Validation == nil {
+		err = waitForProbe(crd, storageVersion(crd), probe, timeout, func(_ *unstructured.Unstructured, err error) (bool, error) {
+			if errors.IsInvalid(err) {
+				return false, nil
+			}
+			return err == nil, err
+		})
+	}
+	var schema, previousSchema *v1beta1.JSONSchemaProps
+	if crd.Spec.Validation != nil {
+		schema = crd.Spec.Validation.OpenAPIV3Schema
+	}
+	if previous != nil {
+		previousSchema = previous.OpenAPIV3Schema
+	}
+	if defaults := hasDefaults(schema); err == nil && defaults != hasDefaults(previousSchema) {
+		defaulted := schema
+		if !defaults {
+			defaulted = previousSchema
+		}
+		probe := defaultingProbe(defaulted)
+		err = waitForProbe(crd, storageVersion(crd), probe.DeepCopy(), timeout, func(obj *unstructured.Unstructured, err error) (bool, error) {
+			if errors.IsInvalid(err) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			defaulted := false
+			for name, value := range probe.Object {
+				if name != "metadata" && !apiequality.Semantic.DeepEqual(obj.Object[name], value) {
+					defaulted = true
+				}
+			}
+			return defaulted == defaults, nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("validation of %s not enforced: %v", crd.Name, err)
+	}
+	return nil
+}
+
+// waitForConversion dry-run creates conversionProbe until the apiserver
+// converts it for storage if crd stores Foo objects at v2 with webhook
+// conversion, and stores it as is otherwise. The apiserver reports failed
+// conversions as internal errors, whatever the webhook answers.
+func waitForConversion(crd *v1beta1.CustomResourceDefinition, timeout time.Duration) error {
+	probe, err := decodeUnstructured(conversionProbe)
+	if err != nil {
+		return err
+	}
+	converted := crd.Spec.Conversion != nil && crd.Spec.Conversion.Strategy == v1beta1.WebhookConverter && storageVersion(crd) != "v1"
+	err = waitForProbe(crd, "v1", probe, timeout, func(_ *unstructured.Unstructured, err error) (bool, error) {
+		switch {
+		case err == nil:
+			return !converted, nil
+		case isInternalError(err):
+			return converted, nil
+		default:
+			return false, err
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("%s not converting=%v to storage version %s: %v", crd.Name, converted, storageVersion(crd), err)
+	}
+	return nil
+}
+
+// isInternalError tells whether err is an API error with status code 500,
+// whatever its reason
+func isInternalError(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	return ok && status.Status().Code == http.StatusInternalServerError
+}
+
+// waitForPruning dry-run creates an object with an unknown field until the
+// apiserver drops the field if prune is true, or keeps it otherwise
+func waitForPruning(crd *v1beta1.CustomResourceDefinition, prune bool, timeout time.Duration) error {
+	probe := &unstructured.Unstructured{Object: map[string]interface{}{"unknownFieldProbe": "unknown"}}
+	probe.SetName("pruning-probe")
+	err := waitForProbe(crd, storageVersion(crd), probe, timeout, func(obj *unstructured.Unstructured, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		_, kept := obj.Object["unknownFieldProbe"]
+		return kept != prune, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unknown fields of %s not pruned=%v: %v", crd.Name, prune, err)
+	}
+	return nil
+}
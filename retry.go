@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamiealquiza/tachymeter"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var (
+	retryPolicyName  = flag.String("retry-policy", "", "retry policy of all scenarios, None, Fixed or Exponential, by default from the scenario name")
+	retryAttempts    = flag.Int("retry-attempts", 5, "maximum number of attempts of a request, including the first one")
+	retryInterval    = flag.Duration("retry-interval", 10*time.Millisecond, "wait before the first retry, and between all retries of the Fixed policy")
+	retryMaxInterval = flag.Duration("retry-max-interval", time.Second, "maximum wait between retries of the Exponential policy")
+)
+
+// retryPolicy decides how often and how long after a failed request it is
+// sent again, like controllers do on conflicts and throttling
+type retryPolicy struct {
+	name     string
+	attempts int
+	interval time.Duration
+	// the wait is multiplied by factor after every retry, up to maxInterval
+	factor      float64
+	maxInterval time.Duration
+	// up to jitter times the wait is added to it at random
+	jitter float64
+}
+
+// getRetryPolicy returns the policy of scenarios named Retry<Policy>, or of
+// --retry-policy, or no retries
+func getRetryPolicy(name string) (retryPolicy, error) {
+	policy := *retryPolicyName
+	if policy == "" {
+		for _, p := range []string{"Fixed", "Exponential"} {
+			if strings.Contains(name, "Retry"+p) {
+				policy = p
+			}
+		}
+	}
+	switch policy {
+	case "", "None":
+		return retryPolicy{name: "None", attempts: 1}, nil
+	case "Fixed":
+		return retryPolicy{name: policy, attempts: *retryAttempts, interval: *retryInterval, factor: 1}, nil
+	case "Exponential":
+		return retryPolicy{name: policy, attempts: *retryAttempts, interval: *retryInterval, factor: 2, maxInterval: *retryMaxInterval, jitter: 1}, nil
+	default:
+		return retryPolicy{}, fmt.Errorf("unknown retry policy %q, want None, Fixed or Exponential", policy)
+	}
+}
+
+// retriable tells whether a request that failed with err is retried: on
+// conflicts and when the apiserver throttles
+func retriable(err error) bool {
+	return errors.IsConflict(err) || errors.IsTooManyRequests(err)
+}
+
+// wait returns how long to wait before the given retry, the first one being 1.
+// A delay the apiserver asks for with Retry-After is waited for at least.
+func (p retryPolicy) wait(retry int, err error) time.Duration {
+	d := p.interval
+	for i := 1; i < retry; i++ {
+		d = time.Duration(float64(d) * p.factor)
+		if p.maxInterval > 0 && d > p.maxInterval {
+			d = p.maxInterval
+			break
+		}
+	}
+	if p.jitter > 0 {
+		d = wait.Jitter(d, p.jitter)
+	}
+	if seconds, ok := errors.SuggestsClientDelay(err); ok {
+		if suggested := time.Duration(seconds) * time.Second; suggested > d {
+			d = suggested
+		}
+	}
+	return d
+}
+
+// retryingClient retries the measured operations of a BenchmarkClient by its
+// policy, and records the latency of every attempt separately from the
+// latency of operations including their retries and waits
+type retryingClient struct {
+	BenchmarkClient
+	policy     retryPolicy
+	attempts   *tachymeter.Tachymeter
+	operations *tachymeter.Tachymeter
+	// number of retried attempts, updated atomically
+	retries int64
+	// paused is 1 while attempts and operations aren't recorded, updated
+	// atomically
+	paused int32
+}
+
+var _ BenchmarkClient = &retryingClient{}
+
+func newRetryingClient(c BenchmarkClient, policy retryPolicy) *retryingClient {
+	return &retryingClient{
+		BenchmarkClient: c,
+		policy:          policy,
+		attempts:        tachymeter.New(&tachymeter.Config{Size: 100000, Safe: true}),
+		operations:      tachymeter.New(&tachymeter.Config{Size: 100000, Safe: true}),
+	}
+}
+
+// reset drops the attempts and operations so far, e.g. of requests setting up
+// a scenario
+func (c *retryingClient) reset() {
+	c.attempts.Reset()
+	c.operations.Reset()
+	atomic.StoreInt64(&c.retries, 0)
+}
+
+// withoutRecording runs f without recording the attempts and operations of
+// its requests, e.g. to prepare objects between measured requests
+func (c *retryingClient) withoutRecording(f func() error) error {
+	atomic.StoreInt32(&c.paused, 1)
+	defer atomic.StoreInt32(&c.paused, 0)
+	return f()
+}
+
+func (c *retryingClient) recording() bool {
+	return atomic.LoadInt32(&c.paused) == 0
+}
+
+// withoutMeasuring runs f without tracing its requests or, if c retries,
+// recording them, e.g. to prepare objects between measured requests
+func withoutMeasuring(c BenchmarkClient, f func() error) error {
+	if r, ok := c.(*retryingClient); ok {
+		return r.withoutRecording(func() error {
+			return requestTraces.withoutTracing(f)
+		})
+	}
+	return requestTraces.withoutTracing(f)
+}
+
+// retry runs op until it succeeds, fails with an error that isn't retried, or
+// runs out of attempts. If refresh is set, it is called with the error of an
+// attempt before retrying, and its error ends the retries.
+func (c *retryingClient) retry(op func() (interface{}, error), refresh func(err error) error) (interface{}, error) {
+	start := time.Now()
+	var obj interface{}
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptStart := time.Now()
+		obj, err = op()
+		recording := c.recording()
+		if recording {
+			c.attempts.AddTime(time.Since(attemptStart))
+		}
+		if err == nil || !retriable(err) || attempt >= c.policy.attempts {
+			break
+		}
+		if recording {
+			atomic.AddInt64(&c.retries, 1)
+		}
+		time.Sleep(c.policy.wait(attempt, err))
+		if refresh != nil {
+			if err = refresh(err); err != nil {
+				obj = nil
+				break
+			}
+		}
+	}
+	if c.recording() {
+		c.operations.AddTime(time.Since(start))
+	}
+	return obj, err
+}
+
+func (c *retryingClient) Create(i int) (interface{}, error) {
+	return c.retry(func() (interface{}, error) {
+		return c.BenchmarkClient.Create(i)
+	}, nil)
+}
+
+// Update reapplies the update to the current object after a conflict, like
+// controllers do, as sending the stale object again would conflict again
+func (c *retryingClient) Update(obj interface{}) (interface{}, error) {
+	return c.retry(func() (interface{}, error) {
+		return c.BenchmarkClient.Update(obj)
+	}, func(err error) error {
+		if !errors.IsConflict(err) {
+			return nil
+		}
+		current, err := c.BenchmarkClient.Get(obj)
+		if err != nil {
+			return fmt.Errorf("failed to get the object to update after a conflict: %v", err)
+		}
+		obj = current
+		return nil
+	})
+}
+
+func (c *retryingClient) List() (interface{}, error) {
+	return c.retry(c.BenchmarkClient.List, nil)
+}
+
+func (c *retryingClient) DeleteCollection() error {
+	_, err := c.retry(func() (interface{}, error) {
+		return nil, c.BenchmarkClient.DeleteCollection()
+	}, nil)
+	return err
+}
+
+// String reports the retries, and the latency of attempts and of operations
+// including retries
+func (c *retryingClient) String() string {
+	return fmt.Sprintf(`retry policy %s: %d retries
+attempt latency:
+%s
+operation latency, including retries:
+%s`, c.policy.name, atomic.LoadInt64(&c.retries), c.attempts.Calc().String(), c.operations.Calc().String())
+}
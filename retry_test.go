@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// failingClient fails Create with the given errors, and succeeds after them
+type failingClient struct {
+	BenchmarkClient
+	errs  []error
+	calls int
+}
+
+func (c *failingClient) Create(i int) (interface{}, error) {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return nil, c.errs[c.calls-1]
+	}
+	return "created", nil
+}
+
+func TestRetryingClient(t *testing.T) {
+	conflict := errors.NewConflict(schema.GroupResource{Resource: "foos"}, "foo", fmt.Errorf("modified"))
+	throttled := errors.NewTooManyRequests("slow down", 0)
+	policy := retryPolicy{name: "Fixed", attempts: 3, interval: time.Millisecond, factor: 1}
+	for _, tc := range []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"conflict and throttling", []error{conflict, throttled}, 3, false},
+		{"out of attempts", []error{conflict, conflict, conflict}, 3, true},
+		{"not retried", []error{errors.NewInternalError(fmt.Errorf("failed"))}, 1, true},
+	} {
+		inner := &failingClient{errs: tc.errs}
+		c := newRetryingClient(inner, policy)
+		_, err := c.Create(0)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tc.name, err, tc.wantErr)
+		}
+		if inner.calls != tc.wantCalls {
+			t.Errorf("%s: got %d attempts, want %d", tc.name, inner.calls, tc.wantCalls)
+		}
+		if c.retries != int64(tc.wantCalls-1) {
+			t.Errorf("%s: got %d retries, want %d", tc.name, c.retries, tc.wantCalls-1)
+		}
+	}
+}
+
+func TestRetryingClientRecordsOnlyMeasuredRequests(t *testing.T) {
+	conflict := errors.NewConflict(schema.GroupResource{Resource: "foos"}, "foo", fmt.Errorf("modified"))
+	inner := &failingClient{errs: []error{conflict, conflict, conflict}}
+	c := newRetryingClient(inner, retryPolicy{name: "Fixed", attempts: 2, interval: time.Millisecond, factor: 1})
+	if _, err := c.Create(0); err == nil {
+		t.Fatal("got no error, want the setup create out of attempts")
+	}
+	c.reset()
+	if c.retries != 0 {
+		t.Errorf("got %d retries after reset, want 0", c.retries)
+	}
+	err := withoutMeasuring(c, func() error {
+		_, err := c.Create(1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls != 4 || c.retries != 0 {
+		t.Errorf("got %d calls and %d retries, want the retry of the unmeasured create left out", inner.calls, c.retries)
+	}
+}
+
+// conflictingClient fails updates of any object but the current one with a
+// conflict
+type conflictingClient struct {
+	BenchmarkClient
+	current string
+	updated []interface{}
+}
+
+func (c *conflictingClient) Update(obj interface{}) (interface{}, error) {
+	c.updated = append(c.updated, obj)
+	if obj != c.current {
+		return nil, errors.NewConflict(schema.GroupResource{Resource: "foos"}, "foo", fmt.Errorf("modified"))
+	}
+	return obj, nil
+}
+
+func (c *conflictingClient) Get(obj interface{}) (interface{}, error) {
+	return c.current, nil
+}
+
+func TestRetryingClientUpdateRefreshesOnConflict(t *testing.T) {
+	inner := &conflictingClient{current: "v2"}
+	c := newRetryingClient(inner, retryPolicy{name: "Fixed", attempts: 3, interval: time.Millisecond, factor: 1})
+	obj, err := c.Update("v1")
+	if err != nil {
+		t.Fatalf("got error %v, want the update reapplied to the current object", err)
+	}
+	if obj != "v2" {
+		t.Errorf("got updated object %v, want v2", obj)
+	}
+	if len(inner.updated) != 2 || inner.updated[1] != "v2" {
+		t.Errorf("got updates of %v, want v1 and then the current v2", inner.updated)
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	p := retryPolicy{attempts: 10, interval: 10 * time.Millisecond, factor: 2, maxInterval: 50 * time.Millisecond}
+	for retry, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 4: 50 * time.Millisecond, 9: 50 * time.Millisecond} {
+		if got := p.wait(retry, nil); got != want {
+			t.Errorf("retry %d: got wait %v, want %v", retry, got, want)
+		}
+	}
+	if got := p.wait(1, errors.NewTooManyRequests("slow down", 2)); got != 2*time.Second {
+		t.Errorf("got wait %v, want the 2s asked for by Retry-After", got)
+	}
+
+	p.jitter = 1
+	if got := p.wait(1, nil); got < 10*time.Millisecond || got > 20*time.Millisecond {
+		t.Errorf("got jittered wait %v, want between 10ms and 20ms", got)
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	for name, want := range map[string]string{
+		"Benchmark_CreateThroughput_CR":                  "None",
+		"Benchmark_CreateThroughput_CR_RetryFixed":       "Fixed",
+		"Benchmark_CreateThroughput_CR_RetryExponential": "Exponential",
+	} {
+		p, err := getRetryPolicy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.name != want {
+			t.Errorf("%s: got policy %s, want %s", name, p.name, want)
+		}
+	}
+}
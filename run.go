@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	runID          = flag.String("run-id", newRunID(), "ID of this run, used in object names and the run ID label, a new one by default")
+	generateName   = flag.Bool("generate-name", false, "let the apiserver generate object names from the run ID instead of numbering them")
+	purgeLeftovers = flag.Bool("purge-leftovers", false, "delete objects left in the benchmark namespace by earlier runs before running")
+	purgeUnlabeled = flag.Bool("purge-unlabeled", false, "delete objects without a run ID label in the benchmark namespace, left by runs from before objects were labeled, before running")
+
+	// label with the run ID on every object created by a benchmark
+	runIDLabel = "stable.example.com/run-id"
+
+	// number of objects named by this process, updated atomically
+	objectSequence int64
+)
+
+// newRunID returns a short lowercase ID that is unique across runs, and valid
+// in object names and label values
+func newRunID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// nameObject gives obj a name unique within this run, from the run ID and a
+// sequence number, or leaves naming to the apiserver with --generate-name, and
+// labels it with the run ID
+func nameObject(obj metav1.Object) {
+	if *generateName {
+		obj.SetName("")
+		obj.SetGenerateName(*runID + "-")
+	} else {
+		obj.SetName(fmt.Sprintf("%s-%d", *runID, atomic.AddInt64(&objectSequence, 1)))
+	}
+	labels := map[string]string{}
+	for k, v := range obj.GetLabels() {
+		labels[k] = v
+	}
+	labels[runIDLabel] = *runID
+	obj.SetLabels(labels)
+}
+
+// runListOptions selects the objects created by this run
+func runListOptions() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", runIDLabel, *runID)}
+}
+
+// leftoverListOptions selects the objects created by other runs
+func leftoverListOptions() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: fmt.Sprintf("%s,%s!=%s", runIDLabel, runIDLabel, *runID)}
+}
+
+// unlabeledListOptions selects the objects without a run ID label, created
+// before objects were labeled
+func unlabeledListOptions() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: "!" + runIDLabel}
+}
+
+// checkLeftovers fails if the namespace of c has objects of other runs, which
+// lists would return and ensureObjectCount would count, unless
+// --purge-leftovers is set to delete them. Objects without a run ID label are
+// only deleted with --purge-unlabeled.
+func checkLeftovers(c BenchmarkClient) error {
+	if err := purge(c, leftoverListOptions(), "left by earlier runs", "--purge-leftovers", *purgeLeftovers); err != nil {
+		return err
+	}
+	return purge(c, unlabeledListOptions(), "without a run ID label", "--purge-unlabeled", *purgeUnlabeled)
+}
+
+// purge deletes the objects selected by opts if enabled by flagName, and fails
+// if there are any otherwise
+func purge(c BenchmarkClient, opts metav1.ListOptions, description, flagName string, enabled bool) error {
+	n, err := c.Leftovers(opts)
+	if err != nil {
+		return fmt.Errorf("failed to check for objects %s: %v", description, err)
+	}
+	if n == 0 {
+		return nil
+	}
+	if !enabled {
+		return fmt.Errorf("found %d objects %s, which lists would return and count, run with %s to delete them", n, description, flagName)
+	}
+	start := time.Now()
+	if err := c.DeleteLeftovers(opts); err != nil {
+		return fmt.Errorf("failed to delete objects %s: %v", description, err)
+	}
+	fmt.Printf("deleted %d objects %s in %v\n", n, description, time.Since(start))
+	return nil
+}
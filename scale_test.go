@@ -1,231 +1,1184 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/jamiealquiza/tachymeter"
+	"golang.org/x/sync/errgroup"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// TODO: TestMain actually runs after benchmarks, so this doesn't help yet
-// func TestMain(m *testing.M) {
-// 	setupNamespace(emptyNamespace)
-// 	setupNamespace(largeDataNamespace)
-// 	setupNamespace(largeMetadataNamespace)
-// 	os.Exit(m.Run())
-// }
+// lastScenario is the scenario that ran last, with a client for its objects.
+// Benchmark functions run once per round of b.N, so the objects of a scenario
+// are kept between its rounds, and only deleted when the next scenario starts,
+// or by TestMain after the last one.
+var lastScenario struct {
+	name   string
+	client BenchmarkClient
+}
+
+// TestMain deletes the objects of the last scenario after all benchmarks
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if lastScenario.client != nil {
+		start := time.Now()
+		if err := lastScenario.client.DeleteCollection(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to clean up objects of %s: %v\n", lastScenario.name, err)
+			if code == 0 {
+				code = 1
+			}
+		} else {
+			fmt.Printf("objects of %s cleaned up in %v\n", lastScenario.name, time.Since(start))
+		}
+	}
+	os.Exit(code)
+}
+
+// startScenario deletes the objects of the previous scenario unless it is
+// name, and records c to clean up the objects of name
+func startScenario(b *testing.B, name string, c BenchmarkClient) {
+	if lastScenario.client != nil && lastScenario.name != name {
+		cleanup(b, lastScenario.client)
+	}
+	lastScenario.name, lastScenario.client = name, c
+}
+
+func runBenchmark(b *testing.B) {
+	// get caller name
+	pc, _, _, _ := runtime.Caller(1)
+	caller := runtime.FuncForPC(pc).Name()
+	if err := setupScenario(caller); err != nil {
+		if _, ok := err.(*unsupportedError); ok {
+			b.Skipf("%s skipped: %v", caller, err)
+		}
+		b.Fatalf("failed to set up %s: %v", caller, err)
+	}
+
+	template, err := getTemplate(caller)
+	if err != nil {
+		b.Fatal(err)
+	}
+	size, err := describeTemplateSize(template)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Log(size)
+	warning, err := requestLimitWarning(template)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if warning != "" {
+		b.Log(warning)
+	}
+
+	c, err := newScenarioClient(caller, template)
+	if err != nil {
+		b.Fatalf("failed to create client of %s: %v", caller, err)
+	}
+	startScenario(b, caller, c)
+	if err := checkLeftovers(c); err != nil {
+		b.Fatal(err)
+	}
+	stats := newErrorStats()
+	defer func() {
+		b.Log(stats)
+		if r, ok := c.(*retryingClient); ok {
+			b.Log(r)
+		}
+	}()
+
+	// objects are prepared before metrics, profiles and traces start, so they
+	// only cover the measurement, like in runScenario
+	if strings.Contains(caller, "List") || strings.Contains(caller, "DeleteCollection") {
+		if err := ensureObjectCount(c, getListSize(caller)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// object updated by update scenarios
+	var updated interface{}
+	if strings.Contains(caller, "UpdateLatency") {
+		if updated, err = c.Create(0); err != nil {
+			b.Fatalf("failed to create object to update: %v", err)
+		}
+	}
+	// object got by get scenarios
+	var got interface{}
+	if strings.Contains(caller, "GetDecode") {
+		if got, err = c.Create(0); err != nil {
+			b.Fatalf("failed to create object to get: %v", err)
+		}
+	}
+
+	// drop the retries of requests setting up the scenario
+	if r, ok := c.(*retryingClient); ok {
+		r.reset()
+	}
+
+	metrics, err := startMetrics(caller, template)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if metrics != nil {
+		defer func() {
+			deltas, err := metrics.stop()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			b.Log(deltas)
+		}()
+	}
+	profiles, err := startProfiles(caller)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if profiles != nil {
+		defer func() {
+			if err := profiles.stop(); err != nil {
+				b.Error(err)
+			}
+		}()
+	}
+	if *traceRequests {
+		requestTraces.reset()
+		defer func() {
+			b.Log(requestTraces)
+		}()
+	}
+	// deferred last, so that the measurement ends before the traces, profiles
+	// and metrics above are torn down
+	defer b.StopTimer()
+
+	if strings.Contains(caller, "CreateLatency") {
+		benchmarkCreateLatency(b, c, stats)
+	} else if strings.Contains(caller, "UpdateLatency") {
+		benchmarkUpdateLatency(b, c, updated, stats)
+	} else if strings.Contains(caller, "CreateThroughput") {
+		benchmarkCreateThroughput(b, c, stats)
+	} else if strings.Contains(caller, "GetDecode") {
+		benchmarkGetDecode(b, c, got, strings.Contains(caller, "Endpoints"), stats)
+	} else if strings.Contains(caller, "ListDecode") {
+		benchmarkListDecode(b, c, strings.Contains(caller, "Endpoints"), stats)
+	} else if strings.Contains(caller, "List") {
+		benchmarkList(b, c, stats)
+	} else if strings.Contains(caller, "Watch") {
+		benchmarkWatch(b, c, getListSize(caller), stats)
+	} else if strings.Contains(caller, "DeleteCollection") {
+		benchmarkDeleteCollection(b, c, getListSize(caller), stats)
+	}
+}
+
+// cleanup deletes the objects created by this run, outside of the measurement
+func cleanup(b *testing.B, c BenchmarkClient) {
+	b.StopTimer()
+	defer b.StartTimer()
+	start := time.Now()
+	if err := c.DeleteCollection(); err != nil {
+		b.Fatalf("failed to clean up objects: %v", err)
+	}
+	b.Logf("objects cleaned up in %v", time.Since(start))
+}
+
+// benchmarkDeleteCollection measures deleting listSize objects at once
+func benchmarkDeleteCollection(b *testing.B, client BenchmarkClient, listSize int, stats *errorStats) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		err := withoutMeasuring(client, func() error {
+			return ensureObjectCount(client, listSize)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := stats.check(client.DeleteCollection()); err != nil {
+			b.Fatalf("failed to delete collection: %v", err)
+		}
+	}
+}
+
+func benchmarkCreateLatency(b *testing.B, client BenchmarkClient, stats *errorStats) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := client.Create(0)
+		if err := stats.check(err); err != nil {
+			b.Fatalf("failed to create object: %v", err)
+		}
+	}
+}
+
+// benchmarkUpdateLatency measures updating obj over and over
+func benchmarkUpdateLatency(b *testing.B, client BenchmarkClient, obj interface{}, stats *errorStats) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		updated, err := client.Update(obj)
+		// after a failed update, the next one starts from the same object
+		if err == nil {
+			obj = updated
+		}
+		if err := stats.check(err); err != nil {
+			b.Fatalf("failed to update object: %v", err)
+		}
+	}
+}
+
+func Benchmark_CreateLatency_CRWithConvert(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Validation(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Validation_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Validation_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Validation(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Validation_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Validation_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationMinimal(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationDeepNesting(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationManyProperties(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationRegex(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationEnum(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationFormat(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_ValidationPreserveUnknownFields(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationMinimal(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationDeepNesting(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationManyProperties(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationRegex(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationEnum(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationFormat(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_ValidationPreserveUnknownFields(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Structural1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_StructuralDefaults1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Structural5(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_StructuralDefaults5(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Structural10(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_StructuralDefaults10(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_KeepUnknown(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_PruneUnknown(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Structural1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_StructuralDefaults1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Structural5(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_StructuralDefaults5(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Structural10(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_StructuralDefaults10(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_KeepUnknown(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_PruneUnknown(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_AdmissionValidating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_AdmissionMutating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_AdmissionBoth(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_AdmissionValidating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_AdmissionMutating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_AdmissionBoth(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CRWithConvert(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CRWithConvert_RetryExponential(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CRWithConvert_AdmissionValidating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CRWithConvert_AdmissionMutating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CRWithConvert_AdmissionBoth(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CR(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CR_AdmissionValidating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CR_AdmissionMutating(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_UpdateLatency_CR_AdmissionBoth(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_PayloadSmallFields(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_PayloadNested(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_PayloadObjectArray(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_PayloadNumeric(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_PayloadRandom(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_PayloadSmallFields(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_PayloadNested(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_PayloadObjectArray(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_PayloadNumeric(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_PayloadRandom(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Size1KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Size10KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Size1MB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Size1KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Size10KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Size1MB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_Size1KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_Size10KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_Size1MB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Varied(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRWithConvert_Varied_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Varied(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Varied_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CR_Varied_PayloadObjectArray(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_Varied_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRStrategyNone(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRStrategyNone_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRStrategyWebhook(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRStrategyWebhook_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRChain2_V1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRChain3_V1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRChain4_V1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRChain4_V2(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRChain4_V3(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_CRChain4_V4(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Typed_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Dynamic(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateLatency_Endpoints_Dynamic_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+// benchmarkCreateThroughput creates --throughput-objects objects, or b.N, with
+// --throughput-concurrency concurrent creates, and reports objects per second
+// and the latency of creates
+func benchmarkCreateThroughput(b *testing.B, client BenchmarkClient, stats *errorStats) {
+	count := *throughputObjects
+	if count <= 0 {
+		count = b.N
+	}
+	concurrency := *throughputConcurrency
+	if concurrency > count {
+		concurrency = count
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	latency := tachymeter.New(&tachymeter.Config{Size: count, Safe: true})
+	// index of the last object taken by a worker, updated atomically
+	next := int64(-1)
+	g, ctx := errgroup.WithContext(context.Background())
+
+	b.ResetTimer()
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		g.Go(func() error {
+			for ctx.Err() == nil {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= count {
+					return nil
+				}
+				requestStart := time.Now()
+				_, err := client.Create(i)
+				if err := stats.check(err); err != nil {
+					return fmt.Errorf("failed to create object %d of %d: %v", i, count, err)
+				}
+				if err == nil {
+					latency.AddTime(time.Since(requestStart))
+				}
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Logf("created %d objects in %v with %d concurrent creates across %d clients: %.1f objects/s",
+		count, elapsed, concurrency, *throughputClients, float64(count)/elapsed.Seconds())
+	b.Logf("create latency:\n%s", latency.Calc())
+}
+
+func Benchmark_CreateThroughput_CRWithConvert(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CRWithConvert_Validation(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CR(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CR_Validation(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CRStrategyNone(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CRStrategyWebhook(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_Endpoints_Typed(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_Endpoints_Dynamic(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CRWithConvert_Varied(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CR_Varied(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CRWithConvert_RetryFixed(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CRWithConvert_RetryExponential(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CR_RetryFixed(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_CreateThroughput_CR_RetryExponential(b *testing.B) {
+	runBenchmark(b)
+}
+
+// benchmarkList measures listing the objects prepared by runBenchmark
+func benchmarkList(b *testing.B, client BenchmarkClient, stats *errorStats) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := client.List()
+		if err := stats.check(err); err != nil {
+			b.Fatalf("failed to list: %v", err)
+		}
+	}
+}
+
+// benchmarkGetDecode gets obj as raw bytes, and decodes every response as
+// unstructured, and as typed Endpoints too if typed is set, reporting the
+// apiserver and transfer time apart from each decode time
+func benchmarkGetDecode(b *testing.B, client BenchmarkClient, obj interface{}, typed bool, stats *errorStats) {
+	server := tachymeter.New(&tachymeter.Config{Size: b.N})
+	unstructuredDecode := tachymeter.New(&tachymeter.Config{Size: b.N})
+	typedDecode := tachymeter.New(&tachymeter.Config{Size: b.N})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		got, err := client.Get(obj)
+		if err := stats.check(err); err != nil {
+			b.Fatalf("failed to get: %v", err)
+		}
+		if err != nil {
+			continue
+		}
+		server.AddTime(time.Since(start))
+		data, ok := got.([]byte)
+		if !ok {
+			b.Fatalf("got %T from Get, decode scenarios need a Raw client", got)
+		}
+
+		start = time.Now()
+		if _, err := decodeUnstructuredObject(data); err != nil {
+			b.Fatalf("failed to decode unstructured object: %v", err)
+		}
+		unstructuredDecode.AddTime(time.Since(start))
+		if typed {
+			start = time.Now()
+			if _, err := decodeEndpoints(data); err != nil {
+				b.Fatalf("failed to decode typed object: %v", err)
+			}
+			typedDecode.AddTime(time.Since(start))
+		}
+	}
+	b.StopTimer()
+
+	b.Logf("apiserver and transfer:\n%s", server.Calc())
+	b.Logf("unstructured decode:\n%s", unstructuredDecode.Calc())
+	if typed {
+		b.Logf("typed decode:\n%s", typedDecode.Calc())
+	}
+}
+
+// benchmarkListDecode lists the objects prepared by runBenchmark as raw bytes,
+// and decodes every list as unstructured, and as typed Endpoints if typed is
+// set, reporting the latency of the apiserver and transfer apart from the
+// decode time
+func benchmarkListDecode(b *testing.B, client BenchmarkClient, typed bool, stats *errorStats) {
+	server := tachymeter.New(&tachymeter.Config{Size: b.N})
+	unstructuredDecode := tachymeter.New(&tachymeter.Config{Size: b.N})
+	typedDecode := tachymeter.New(&tachymeter.Config{Size: b.N})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		obj, err := client.List()
+		if err := stats.check(err); err != nil {
+			b.Fatalf("failed to list: %v", err)
+		}
+		if err != nil {
+			continue
+		}
+		server.AddTime(time.Since(start))
+		data, ok := obj.([]byte)
+		if !ok {
+			b.Fatalf("got %T from List, decode scenarios need a Raw client", obj)
+		}
+
+		start = time.Now()
+		if _, err := decodeUnstructuredList(data); err != nil {
+			b.Fatalf("failed to decode unstructured list: %v", err)
+		}
+		unstructuredDecode.AddTime(time.Since(start))
+		if typed {
+			start = time.Now()
+			if _, err := decodeEndpointsList(data); err != nil {
+				b.Fatalf("failed to decode typed list: %v", err)
+			}
+			typedDecode.AddTime(time.Since(start))
+		}
+	}
+	b.StopTimer()
+
+	b.Logf("apiserver and transfer:\n%s", server.Calc())
+	b.Logf("unstructured decode:\n%s", unstructuredDecode.Calc())
+	if typed {
+		b.Logf("typed decode:\n%s", typedDecode.Calc())
+	}
+}
+
+func Benchmark_List_CRWithConvert(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Validation(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Validation_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Validation_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_Validation(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_Validation_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_Validation_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationMinimal(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationDeepNesting(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationManyProperties(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationRegex(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationEnum(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationFormat(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_ValidationPreserveUnknownFields(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationMinimal(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationDeepNesting(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationManyProperties(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationRegex(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationEnum(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationFormat(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CR_ValidationPreserveUnknownFields(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Structural1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_StructuralDefaults1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Structural5(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_StructuralDefaults5(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Structural10(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_StructuralDefaults10(b *testing.B) {
+	runBenchmark(b)
+}
 
-func runBenchmark(b *testing.B) {
-	// TODO: this is a workaround for go-benchmark not supporting before-benchmark setup
-	setupNamespace(emptyNamespace)
-	setupNamespace(largeDataNamespace)
-	setupNamespace(largeMetadataNamespace)
+func Benchmark_List_CRWithConvert_KeepUnknown(b *testing.B) {
+	runBenchmark(b)
+}
 
-	// get caller name
-	pc, _, _, _ := runtime.Caller(1)
-	caller := runtime.FuncForPC(pc).Name()
-	setupValidation(strings.Contains(caller, "Validation"))
+func Benchmark_List_CRWithConvert_PruneUnknown(b *testing.B) {
+	runBenchmark(b)
+}
 
-	var c BenchmarkClient
-	if strings.Contains(caller, "Typed") {
-		c = mustNewEndpointsBenchmarkClient(getNamespace(caller), getTemplate(caller), getListOptions(caller))
-	} else {
-		c = mustNewDynamicBenchmarkClient(getGVR(caller), getNamespace(caller), getTemplate(caller), getListOptions(caller))
-	}
+func Benchmark_List_CR_Structural1(b *testing.B) {
+	runBenchmark(b)
+}
 
-	if strings.Contains(caller, "CreateLatency") {
-		benchmarkCreateLatency(b, c)
-	} else if strings.Contains(caller, "CreateThroughput") {
-		benchmarkCreateThroughput(b, c)
-	} else if strings.Contains(caller, "List") {
-		benchmarkList(b, c, testListSize)
-	}
+func Benchmark_List_CR_StructuralDefaults1(b *testing.B) {
+	runBenchmark(b)
 }
 
-func benchmarkCreateLatency(b *testing.B, client BenchmarkClient) {
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := client.Create(0)
-		if err != nil {
-			b.Fatalf("failed to create object: %v", err)
-		}
-	}
+func Benchmark_List_CR_Structural5(b *testing.B) {
+	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CRWithConvert(b *testing.B) {
+func Benchmark_List_CR_StructuralDefaults5(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CRWithConvert_LargeData(b *testing.B) {
+func Benchmark_List_CR_Structural10(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CRWithConvert_LargeMetadata(b *testing.B) {
+func Benchmark_List_CR_StructuralDefaults10(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CRWithConvert_Validation(b *testing.B) {
+func Benchmark_List_CR_KeepUnknown(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CRWithConvert_Validation_LargeData(b *testing.B) {
+func Benchmark_List_CR_PruneUnknown(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CRWithConvert_Validation_LargeMetadata(b *testing.B) {
+func Benchmark_List_CRWithConvert_PayloadSmallFields(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CR(b *testing.B) {
+func Benchmark_List_CRWithConvert_PayloadNested(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CR_LargeData(b *testing.B) {
+func Benchmark_List_CRWithConvert_PayloadObjectArray(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CR_LargeMetadata(b *testing.B) {
+func Benchmark_List_CRWithConvert_PayloadNumeric(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CR_Validation(b *testing.B) {
+func Benchmark_List_CRWithConvert_PayloadRandom(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CR_Validation_LargeData(b *testing.B) {
+func Benchmark_List_CR_PayloadSmallFields(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_CR_Validation_LargeMetadata(b *testing.B) {
+func Benchmark_List_CR_PayloadNested(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_Endpoints_Typed(b *testing.B) {
+func Benchmark_List_CR_PayloadObjectArray(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_Endpoints_Typed_LargeMetadata(b *testing.B) {
+func Benchmark_List_CR_PayloadNumeric(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_Endpoints_Dynamic(b *testing.B) {
+func Benchmark_List_CR_PayloadRandom(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateLatency_Endpoints_Dynamic_LargeMetadata(b *testing.B) {
+func Benchmark_List_CRWithConvert_Size1KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func benchmarkCreateThroughput(b *testing.B, client BenchmarkClient) {
-	b.ResetTimer()
-	var wg sync.WaitGroup
-	count := 100
-	start := time.Now()
-	wg.Add(count)
-	for i := 0; i < count; i++ {
-		// deep copy i
-		idx := i
-		go func() {
-			_, err := client.Create(idx)
-			if err != nil {
-				// TODO: b.Fatal doesn't raise
-				// b.Fatalf("failed to create object: %v", err)
-				panic(err)
-			}
-			wg.Done()
-		}()
-	}
-	wg.Wait()
-	fmt.Printf("created %d objects in %v\n", count, time.Now().Sub(start))
+func Benchmark_List_CRWithConvert_Size10KB(b *testing.B) {
+	runBenchmark(b)
 }
 
-func Benchmark_CreateThroughput_CRWithConvert(b *testing.B) {
+func Benchmark_List_CRWithConvert_Size100KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateThroughput_CRWithConvert_Validation(b *testing.B) {
+func Benchmark_List_CRWithConvert_Size500KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateThroughput_CR(b *testing.B) {
+func Benchmark_List_CRWithConvert_Size1MB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateThroughput_CR_Validation(b *testing.B) {
+func Benchmark_List_CR_Size1KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateThroughput_Endpoints_Typed(b *testing.B) {
+func Benchmark_List_CR_Size10KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_CreateThroughput_Endpoints_Dynamic(b *testing.B) {
+func Benchmark_List_CR_Size100KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func benchmarkList(b *testing.B, client BenchmarkClient, listSize int) {
-	if err := ensureObjectCount(client, listSize); err != nil {
-		b.Fatal(err)
-	}
+func Benchmark_List_CR_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := client.List()
-		if err != nil {
-			b.Fatalf("failed to list: %v", err)
-		}
-	}
+func Benchmark_List_CR_Size1MB(b *testing.B) {
+	runBenchmark(b)
 }
 
-func Benchmark_List_CRWithConvert(b *testing.B) {
+func Benchmark_List_Endpoints_Typed_Size1KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CRWithConvert_LargeData(b *testing.B) {
+func Benchmark_List_Endpoints_Typed_Size10KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CRWithConvert_LargeMetadata(b *testing.B) {
+func Benchmark_List_Endpoints_Typed_Size100KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CRWithConvert_Validation(b *testing.B) {
+func Benchmark_List_Endpoints_Typed_Size500KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CRWithConvert_Validation_LargeData(b *testing.B) {
+func Benchmark_List_Endpoints_Typed_Size1MB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CRWithConvert_Validation_LargeMetadata(b *testing.B) {
+func Benchmark_List_CRWithConvert_Varied(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CR(b *testing.B) {
+func Benchmark_List_CRWithConvert_Varied_LargeData(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CR_LargeData(b *testing.B) {
+func Benchmark_List_CR_Varied(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CR_LargeMetadata(b *testing.B) {
+func Benchmark_List_CR_Varied_LargeData(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CR_Validation(b *testing.B) {
+func Benchmark_List_CR_Varied_PayloadObjectArray(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CR_Validation_LargeData(b *testing.B) {
+func Benchmark_List_Endpoints_Typed_Varied_Size100KB(b *testing.B) {
 	runBenchmark(b)
 }
 
-func Benchmark_List_CR_Validation_LargeMetadata(b *testing.B) {
+func Benchmark_List_CRStrategyNone(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRStrategyNone_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRStrategyWebhook(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRStrategyWebhook_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRChain2_V1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRChain3_V1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRChain4_V1(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRChain4_V2(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRChain4_V3(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRChain4_V4(b *testing.B) {
 	runBenchmark(b)
 }
 
@@ -245,6 +1198,70 @@ func Benchmark_List_Endpoints_Dynamic_LargeMetadata(b *testing.B) {
 	runBenchmark(b)
 }
 
+func Benchmark_List_Endpoints_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_Endpoints_Raw_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_CRWithConvert_Raw_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_ListDecode_Endpoints_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_ListDecode_Endpoints_Raw_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_ListDecode_CR_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_ListDecode_CR_Raw_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_ListDecode_CRWithConvert_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_ListDecode_CRWithConvert_Raw_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_GetDecode_Endpoints_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_GetDecode_Endpoints_Raw_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_GetDecode_CR_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_GetDecode_CR_Raw_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_GetDecode_CRWithConvert_Raw(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_GetDecode_CRWithConvert_Raw_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
 func Benchmark_List_WatchCache_CRWithConvert(b *testing.B) {
 	runBenchmark(b)
 }
@@ -293,6 +1310,22 @@ func Benchmark_List_WatchCache_CR_Validation_LargeMetadata(b *testing.B) {
 	runBenchmark(b)
 }
 
+func Benchmark_List_WatchCache_CRStrategyNone(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_WatchCache_CRStrategyNone_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_WatchCache_CRStrategyWebhook(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_List_WatchCache_CRStrategyWebhook_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
 func Benchmark_List_WatchCache_Endpoints_Typed(b *testing.B) {
 	runBenchmark(b)
 }
@@ -309,52 +1342,204 @@ func Benchmark_List_WatchCache_Endpoints_Dynamic_LargeMetadata(b *testing.B) {
 	runBenchmark(b)
 }
 
-func benchmarkWatch(b *testing.B, client BenchmarkClient, listSize int) {
+func benchmarkWatch(b *testing.B, client BenchmarkClient, listSize int, stats *errorStats) {
 	watcherCount := 1000
 	events := b.N
+	// watches from no resource version start with an event per existing
+	// object, so every round starts without the objects of earlier rounds
+	cleanup(b, client)
+	// number of successful creates, updated atomically
+	var created int64
+	// closed once all creates returned
+	createsDone := make(chan struct{})
 	var readyWg sync.WaitGroup
-	var doneWg sync.WaitGroup
+	var watchers errgroup.Group
 	readyWg.Add(watcherCount)
-	doneWg.Add(watcherCount)
 	start := time.Now()
 	for i := 0; i < watcherCount; i++ {
-		go func() {
+		watchers.Go(func() error {
 			watcher, err := client.Watch()
+			readyWg.Done()
+			if err := stats.check(err); err != nil {
+				return fmt.Errorf("failed to watch: %v", err)
+			}
 			if err != nil {
-				b.Fatalf("failed to watch: %v", err)
+				return nil
 			}
-			readyWg.Done()
-			for j := 0; j < events; j++ {
-				<-watcher.ResultChan()
+			defer watcher.Stop()
+			// with --keep-going, only the successful creates send events
+			seen := 0
+			for seen < events {
+				select {
+				case <-watcher.ResultChan():
+					seen++
+				case <-createsDone:
+					for ; seen < int(atomic.LoadInt64(&created)); seen++ {
+						<-watcher.ResultChan()
+					}
+					return nil
+				}
 			}
-			watcher.Stop()
-			doneWg.Done()
-		}()
+			return nil
+		})
 	}
 	readyWg.Wait()
 	fmt.Printf("created %d watches in %v\n", watcherCount, time.Now().Sub(start))
 	start = time.Now()
 	b.ResetTimer()
+	var creates errgroup.Group
 	for i := 0; i < events; i++ {
-		go func() {
-			client.Create(i)
-		}()
+		// deep copy i
+		idx := i
+		creates.Go(func() error {
+			_, err := client.Create(idx)
+			if err := stats.check(err); err != nil {
+				return fmt.Errorf("failed to create object %d of %d: %v", idx, events, err)
+			}
+			if err == nil {
+				atomic.AddInt64(&created, 1)
+			}
+			return nil
+		})
+	}
+	// watchers wait for the event of every successful create, so they are
+	// only waited for once all creates returned
+	err := creates.Wait()
+	close(createsDone)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := watchers.Wait(); err != nil {
+		b.Fatal(err)
 	}
-	doneWg.Wait()
-	fmt.Printf("processed %d watch events in %v\n", watcherCount*events, time.Now().Sub(start))
+	fmt.Printf("processed %d watch events in %v\n", watcherCount*int(created), time.Now().Sub(start))
 }
 
 func BenchmarkWatchCRWithConvert(b *testing.B) {
-	c := mustNewDynamicBenchmarkClient(foov1GVR, emptyNamespace, foov1Template, &metav1.ListOptions{})
-	benchmarkWatch(b, c, testListSize)
+	config, err := newRESTConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	c, err := newDynamicBenchmarkClient(config, foov1GVR, emptyNamespace, foov1Template, &metav1.ListOptions{}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	startScenario(b, "BenchmarkWatchCRWithConvert", c)
+	stats := newErrorStats()
+	defer func() { b.Log(stats) }()
+	benchmarkWatch(b, c, testListSize, stats)
 }
 
 func BenchmarkWatchCR(b *testing.B) {
-	c := mustNewDynamicBenchmarkClient(barGVR, emptyNamespace, barTemplate, &metav1.ListOptions{})
-	benchmarkWatch(b, c, testListSize)
+	config, err := newRESTConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	c, err := newDynamicBenchmarkClient(config, barGVR, emptyNamespace, barTemplate, &metav1.ListOptions{}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	startScenario(b, "BenchmarkWatchCR", c)
+	stats := newErrorStats()
+	defer func() { b.Log(stats) }()
+	benchmarkWatch(b, c, testListSize, stats)
 }
 
 func BenchmarkWatchEndpointsTyped(b *testing.B) {
-	c := mustNewEndpointsBenchmarkClient(emptyNamespace, endpointsTemplate, &metav1.ListOptions{})
-	benchmarkWatch(b, c, testListSize)
+	config, err := newRESTConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	c, err := newEndpointsBenchmarkClient(config, emptyNamespace, endpointsTemplate, &metav1.ListOptions{}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	startScenario(b, "BenchmarkWatchEndpointsTyped", c)
+	stats := newErrorStats()
+	defer func() { b.Log(stats) }()
+	benchmarkWatch(b, c, testListSize, stats)
+}
+
+func Benchmark_DeleteCollection_CRWithConvert(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_DeleteCollection_CRWithConvert_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_DeleteCollection_CR(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_DeleteCollection_CR_LargeData(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_DeleteCollection_Endpoints_Typed(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_DeleteCollection_Endpoints_Typed_LargeMetadata(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CRWithConvert_Size1KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CRWithConvert_Size10KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CRWithConvert_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CRWithConvert_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CRWithConvert_Size1MB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CR_Size1KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CR_Size10KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CR_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CR_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_CR_Size1MB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_Endpoints_Typed_Size1KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_Endpoints_Typed_Size10KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_Endpoints_Typed_Size100KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_Endpoints_Typed_Size500KB(b *testing.B) {
+	runBenchmark(b)
+}
+
+func Benchmark_Watch_Endpoints_Typed_Size1MB(b *testing.B) {
+	runBenchmark(b)
 }
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	validationProfileFlag = flag.String("validation-schema", "", "validation profile or schema file to use in Validation scenarios, overriding the profile in the scenario name")
+
+	// e.g. Benchmark_List_CR_ValidationDeepNesting uses the DeepNesting profile,
+	// Benchmark_List_CR_Validation uses the Default one
+	validationProfileRegexp = regexp.MustCompile(`Validation([A-Z][A-Za-z]*)?`)
+	// e.g. Benchmark_Create_CR_StructuralDefaults5 defaults fields at 5 levels of
+	// nesting, Benchmark_Create_CR_Structural5 has the same schema without defaults
+	structuralProfileRegexp = regexp.MustCompile(`(StructuralDefaults|Structural)(\d+)`)
+	// PruneUnknown and KeepUnknown create objects with unknown fields, with
+	// pruning on and off
+	unknownFieldsProfileRegexp = regexp.MustCompile(`(PruneUnknown|KeepUnknown)`)
+
+	deepNestingDepth    = 20
+	manyPropertiesCount = 200
+	profileItemCount    = 100
+)
+
+// validationProfile is a schema for spec, together with spec fields that
+// exercise it. The fields are added to the object template of scenarios using
+// the profile.
+type validationProfile struct {
+	specSchema map[string]interface{}
+	spec       map[string]interface{}
+	// keepUnknown profiles set preserveUnknownFields, others have unknown fields
+	// pruned, like crd-template.yaml. Defaulting requires pruning.
+	keepUnknown bool
+	// requiresPruning profiles are unsupported by apiservers without pruning
+	requiresPruning bool
+}
+
+var validationProfiles = map[string]func() validationProfile{
+	"Minimal":               minimalProfile,
+	"DeepNesting":           deepNestingProfile,
+	"ManyProperties":        manyPropertiesProfile,
+	"Regex":                 regexProfile,
+	"Enum":                  enumProfile,
+	"Format":                formatProfile,
+	"PreserveUnknownFields": preserveUnknownFieldsProfile,
+}
+
+// getValidationProfile returns the validation profile or schema file of a
+// scenario, or "" if it runs without validation
+func getValidationProfile(name string) string {
+	if m := structuralProfileRegexp.FindString(name); m != "" {
+		return m
+	}
+	if m := unknownFieldsProfileRegexp.FindString(name); m != "" {
+		return m
+	}
+	m := validationProfileRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	if *validationProfileFlag != "" {
+		return *validationProfileFlag
+	}
+	if m[1] == "" {
+		return "Default"
+	}
+	return m[1]
+}
+
+// lookupValidationProfile builds the named profile, if there is one
+func lookupValidationProfile(profile string) (validationProfile, bool) {
+	if m := structuralProfileRegexp.FindStringSubmatch(profile); m != nil && m[0] == profile {
+		depth, _ := strconv.Atoi(m[2])
+		return structuralProfile(depth, m[1] == "StructuralDefaults"), true
+	}
+	switch profile {
+	case "PruneUnknown":
+		return unknownFieldsProfile(true), true
+	case "KeepUnknown":
+		return unknownFieldsProfile(false), true
+	}
+	newProfile, ok := validationProfiles[profile]
+	if !ok {
+		return validationProfile{}, false
+	}
+	return newProfile(), true
+}
+
+// validationProfileData returns the validation of given profile as YAML, or
+// reads it from a file in the same format as validationSchema
+func validationProfileData(profile string) ([]byte, error) {
+	if profile == "Default" {
+		return validationSchema, nil
+	}
+	if p, ok := lookupValidationProfile(profile); ok {
+		return yaml.Marshal(map[string]interface{}{
+			"openAPIV3Schema": objectSchema(map[string]interface{}{
+				"spec": p.specSchema,
+			}),
+		})
+	}
+	data, err := ioutil.ReadFile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a validation profile nor a readable schema file: %v", profile, err)
+	}
+	return data, nil
+}
+
+// newValidationProfile builds the validation of given profile. The vendored
+// apiextensions types lack newer schema fields, e.g.
+// x-kubernetes-preserve-unknown-fields, so it is only good for reading the
+// schema, newValidationObject builds the validation set on CRDs.
+func newValidationProfile(profile string) (*v1beta1.CustomResourceValidation, error) {
+	data, err := validationProfileData(profile)
+	if err != nil {
+		return nil, err
+	}
+	v := v1beta1.CustomResourceValidation{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode validation profile %q: %v", profile, err)
+	}
+	return &v, nil
+}
+
+// newValidationObject builds the validation of given profile as unstructured,
+// with all its schema fields
+func newValidationObject(profile string) (map[string]interface{}, error) {
+	data, err := validationProfileData(profile)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode validation profile %q: %v", profile, err)
+	}
+	// decoded like the dynamic client does, with integers as int64, so that it
+	// compares equal to the validation of a CRD
+	v := map[string]interface{}{}
+	if err := utiljson.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode validation profile %q: %v", profile, err)
+	}
+	return v, nil
+}
+
+// addProfileSpec adds the spec fields of given profile to an object template
+func addProfileSpec(data []byte, profile string) ([]byte, error) {
+	p, ok := lookupValidationProfile(profile)
+	if !ok {
+		return data, nil
+	}
+	u := unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	for k, v := range p.spec {
+		if err := unstructured.SetNestedField(u.Object, v, "spec", k); err != nil {
+			return nil, err
+		}
+	}
+	return yaml.Marshal(&u)
+}
+
+func objectSchema(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func arraySchema(items map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+func minimalProfile() validationProfile {
+	return validationProfile{specSchema: map[string]interface{}{"type": "object"}}
+}
+
+func deepNestingProfile() validationProfile {
+	schema := map[string]interface{}{"type": "string", "maxLength": int64(64)}
+	var spec interface{} = "leaf"
+	for i := 0; i < deepNestingDepth; i++ {
+		schema = objectSchema(map[string]interface{}{"nested": schema})
+		spec = map[string]interface{}{"nested": spec}
+	}
+	return validationProfile{specSchema: schema, spec: spec.(map[string]interface{})}
+}
+
+func manyPropertiesProfile() validationProfile {
+	properties := map[string]interface{}{}
+	spec := map[string]interface{}{}
+	for i := 0; i < manyPropertiesCount; i++ {
+		name := fmt.Sprintf("field%03d", i)
+		if i%2 == 0 {
+			properties[name] = map[string]interface{}{"type": "string", "maxLength": int64(32)}
+			spec[name] = fmt.Sprintf("value-%d", i)
+		} else {
+			properties[name] = map[string]interface{}{"type": "integer", "minimum": int64(0)}
+			spec[name] = int64(i)
+		}
+	}
+	return validationProfile{specSchema: objectSchema(properties), spec: spec}
+}
+
+func regexProfile() validationProfile {
+	tokens := []interface{}{}
+	for i := 0; i < profileItemCount; i++ {
+		tokens = append(tokens, fmt.Sprintf("service-%d.namespace-%d.svc.cluster.local", i, i))
+	}
+	return validationProfile{
+		specSchema: objectSchema(map[string]interface{}{
+			// DNS subdomain, as used for Kubernetes object names
+			"tokens": arraySchema(map[string]interface{}{
+				"type":    "string",
+				"pattern": `^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`,
+			}),
+			"dummy": arraySchema(map[string]interface{}{
+				"type":    "string",
+				"pattern": "dummy-[0-9]+",
+			}),
+		}),
+		spec: map[string]interface{}{"tokens": tokens},
+	}
+}
+
+func enumProfile() validationProfile {
+	values := []interface{}{}
+	for i := 0; i < 50; i++ {
+		values = append(values, fmt.Sprintf("color-%02d", i))
+	}
+	colors := []interface{}{}
+	for i := 0; i < profileItemCount; i++ {
+		colors = append(colors, values[i%len(values)])
+	}
+	return validationProfile{
+		specSchema: objectSchema(map[string]interface{}{
+			"colors": arraySchema(map[string]interface{}{"type": "string", "enum": values}),
+		}),
+		spec: map[string]interface{}{"colors": colors},
+	}
+}
+
+func formatProfile() validationProfile {
+	records := []interface{}{}
+	for i := 0; i < profileItemCount; i++ {
+		records = append(records, map[string]interface{}{
+			"ip":        fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			"timestamp": "2019-04-24T21:24:40Z",
+			"id":        fmt.Sprintf("123e4567-e89b-12d3-a456-%012d", i),
+			"host":      fmt.Sprintf("node-%d.example.com", i),
+			"email":     fmt.Sprintf("user-%d@example.com", i),
+		})
+	}
+	return validationProfile{
+		specSchema: objectSchema(map[string]interface{}{
+			"records": arraySchema(objectSchema(map[string]interface{}{
+				"ip":        map[string]interface{}{"type": "string", "format": "ipv4"},
+				"timestamp": map[string]interface{}{"type": "string", "format": "date-time"},
+				"id":        map[string]interface{}{"type": "string", "format": "uuid"},
+				"host":      map[string]interface{}{"type": "string", "format": "hostname"},
+				"email":     map[string]interface{}{"type": "string", "format": "email"},
+			})),
+		}),
+		spec: map[string]interface{}{"records": records},
+	}
+}
+
+func preserveUnknownFieldsProfile() validationProfile {
+	spec := map[string]interface{}{}
+	for i := 0; i < manyPropertiesCount; i++ {
+		spec[fmt.Sprintf("unknown%03d", i)] = fmt.Sprintf("value-%d", i)
+	}
+	return validationProfile{
+		specSchema: map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true},
+		spec:       spec,
+	}
+}
+
+// structuralProfile nests objects depth levels deep, each with a string field.
+// With defaults, the objects and fields are all left out of the spec and filled
+// in by the apiserver.
+func structuralProfile(depth int, defaults bool) validationProfile {
+	var schema map[string]interface{}
+	for i := depth; i > 0; i-- {
+		value := map[string]interface{}{"type": "string"}
+		properties := map[string]interface{}{"value": value}
+		if defaults {
+			value["default"] = fmt.Sprintf("default-%d", i)
+		}
+		if schema != nil {
+			properties["nested"] = schema
+		}
+		schema = objectSchema(properties)
+		if defaults {
+			schema["default"] = map[string]interface{}{}
+		}
+	}
+	return validationProfile{
+		specSchema: objectSchema(map[string]interface{}{
+			"name":   map[string]interface{}{"type": "string"},
+			"nested": schema,
+		}),
+		spec:            map[string]interface{}{"name": "structural"},
+		requiresPruning: true,
+	}
+}
+
+// unknownFieldsProfile has a single known field in spec, next to many unknown
+// ones, which are dropped if prune is true
+func unknownFieldsProfile(prune bool) validationProfile {
+	spec := map[string]interface{}{"known": "value"}
+	for i := 0; i < manyPropertiesCount; i++ {
+		spec[fmt.Sprintf("unknown%03d", i)] = map[string]interface{}{
+			"value": fmt.Sprintf("value-%d", i),
+			"items": []interface{}{"a", "b", "c"},
+		}
+	}
+	return validationProfile{
+		specSchema: objectSchema(map[string]interface{}{
+			"known": map[string]interface{}{"type": "string"},
+		}),
+		spec:            spec,
+		keepUnknown:     !prune,
+		requiresPruning: prune,
+	}
+}
+
+// profilePruning tells whether the Foo and Bar CRDs prune unknown fields with
+// given profile, and whether its scenarios require pruning. Every scenario
+// sets pruning, so that it doesn't depend on the scenario run before: without
+// a profile unknown fields are kept, as pruning needs a schema, with schema
+// files and profiles that don't keep them they are pruned, as with
+// crd-template.yaml.
+func profilePruning(profile string) (prune, required bool) {
+	if profile == "" {
+		return false, false
+	}
+	p, ok := lookupValidationProfile(profile)
+	if !ok {
+		return true, false
+	}
+	return !p.keepUnknown, p.requiresPruning
+}
+
+// hasDefaults tells whether schema defaults any field
+func hasDefaults(schema *v1beta1.JSONSchemaProps) bool {
+	if schema == nil {
+		return false
+	}
+	if schema.Default != nil {
+		return true
+	}
+	for _, property := range schema.Properties {
+		if hasDefaults(&property) {
+			return true
+		}
+	}
+	if schema.Items != nil {
+		if hasDefaults(schema.Items.Schema) {
+			return true
+		}
+		for _, item := range schema.Items.JSONSchemas {
+			if hasDefaults(&item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureValidation makes sure given CRD has expected validation set / unset,
+// and prunes unknown fields if prune is set, in one update: a pruning CRD needs
+// a structural schema, and a schema with defaults needs pruning, so neither can
+// change before the other. Apiservers without pruning drop
+// preserveUnknownFields, so they keep unknown fields, which is an
+// unsupportedError if pruning is required. The update is tried with dry run
+// first, and if the apiserver drops or rejects the defaults of the schema, an
+// unsupportedError is returned too.
+func ensureValidation(name string, validation map[string]interface{}, prune, required bool) error {
+	client, crd, err := getCRD(name)
+	if err != nil {
+		return err
+	}
+	previous, err := typedCRD(crd)
+	if err != nil {
+		return err
+	}
+	preserve, supported, err := unstructured.NestedBool(crd.Object, "spec", "preserveUnknownFields")
+	if err != nil {
+		return err
+	}
+	if !supported {
+		if prune && required {
+			return &unsupportedError{reason: "apiserver does not support pruning, it drops spec.preserveUnknownFields of " + name}
+		}
+		prune = false
+	}
+	current, _, err := unstructured.NestedMap(crd.Object, "spec", "validation")
+	if err != nil {
+		return err
+	}
+	if apiequality.Semantic.DeepEqual(validation, current) && (!supported || preserve == !prune) {
+		return nil
+	}
+
+	unstructured.RemoveNestedField(crd.Object, "spec", "validation")
+	if validation != nil {
+		if err := unstructured.SetNestedMap(crd.Object, validation, "spec", "validation"); err != nil {
+			return err
+		}
+	}
+	if supported {
+		if err := unstructured.SetNestedField(crd.Object, !prune, "spec", "preserveUnknownFields"); err != nil {
+			return err
+		}
+	}
+	updated, err := typedCRD(crd)
+	if err != nil {
+		return err
+	}
+	defaults := updated.Spec.Validation != nil && hasDefaults(updated.Spec.Validation.OpenAPIV3Schema)
+	dryRun, err := client.Update(crd, metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}})
+	if errors.IsInvalid(err) && defaults && strings.Contains(err.Error(), "default") {
+		return &unsupportedError{reason: fmt.Sprintf("apiserver rejects defaults in the schema of %s: %v", name, err)}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update validation and pruning of %s: %v", name, err)
+	}
+	if defaults {
+		accepted, err := typedCRD(dryRun)
+		if err != nil {
+			return err
+		}
+		if accepted.Spec.Validation == nil || !hasDefaults(accepted.Spec.Validation.OpenAPIV3Schema) {
+			return &unsupportedError{reason: "apiserver does not support defaulting, it drops the defaults in the schema of " + name}
+		}
+	}
+
+	crd, err = client.Update(crd, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update validation and pruning of %s: %v", name, err)
+	}
+	if updated, err = typedCRD(crd); err != nil {
+		return err
+	}
+	if err := waitForValidation(updated, previous.Spec.Validation, *crdReadyTimeout); err != nil {
+		return err
+	}
+	return waitForPruning(updated, prune, *crdReadyTimeout)
+}
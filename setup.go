@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1beta1", Resource: "customresourcedefinitions"}
+
+	webhookNamespace   = "default"
+	webhookPodName     = "example-conversion-webhook"
+	webhookServiceName = "webhook-service"
+	webhookSecretName  = "webhook-tls-certs"
+)
+
+// fooCRD, barCRD, webhookPod and webhookService are generated from artifacts/
+// into artifacts.go, run go generate after changing them.
+//
+//go:generate go run hack/gen-artifacts.go
+
+// runSetup installs the CRDs, the conversion webhook and the test namespaces.
+// It is safe to run against a cluster that is already set up.
+func runSetup(args []string) {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	image := fs.String("webhook-image", os.Getenv("WEBHOOK_IMAGE"), "image of the conversion webhook, built by make push_webhook_image")
+	timeout := fs.Duration("timeout", 2*time.Minute, "how long to wait for each CRD and the webhook to become ready")
+	profiling := fs.Bool("webhook-profiling", false, "run the webhook with --profiling, so that benchmarks can capture its profiles with --profile")
+	fs.DurationVar(namespaceReadyTimeout, "namespace-ready-timeout", *namespaceReadyTimeout, "how long to wait for each test namespace to become usable")
+	fs.Parse(args)
+	if *image == "" {
+		fmt.Fprintln(os.Stderr, "--webhook-image or WEBHOOK_IMAGE is required")
+		os.Exit(1)
+	}
+
+	if err := setupCluster(*image, *profiling, *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("cluster set up")
+}
+
+func setupCluster(image string, profiling bool, timeout time.Duration) error {
+	clientset, err := newClientset()
+	if err != nil {
+		return err
+	}
+	if err := installCRDs(clientset, timeout); err != nil {
+		return err
+	}
+
+	if err := applyWebhook(clientset, image, profiling, timeout); err != nil {
+		return err
+	}
+	if err := waitForEndpoints(clientset, webhookNamespace, webhookServiceName, timeout); err != nil {
+		return err
+	}
+	fmt.Printf("%s ready\n", webhookServiceName)
+
+	for _, namespace := range []string{emptyNamespace, largeDataNamespace, largeMetadataNamespace} {
+		if err := setupNamespace(namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installCRDs creates or updates the Foo and Bar CRDs, trusting the CA in the
+// webhook secret for conversion
+func installCRDs(clientset *kubernetes.Clientset, timeout time.Duration) error {
+	crdClient, err := newCRDClient()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		return err
+	}
+	crds := dynamicClient.Resource(crdGVR)
+
+	secret, err := clientset.CoreV1().Secrets(webhookNamespace).Get(webhookSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get webhook serving certificate: %v", err)
+	}
+	foo, err := decodeUnstructured(fooCRD)
+	if err != nil {
+		return err
+	}
+	caBundle := base64.StdEncoding.EncodeToString(secretCABundle(secret))
+	if err := unstructured.SetNestedField(foo.Object, caBundle, "spec", "conversion", "webhookClientConfig", "caBundle"); err != nil {
+		return err
+	}
+	bar, err := decodeUnstructured(barCRD)
+	if err != nil {
+		return err
+	}
+	for _, crd := range []*unstructured.Unstructured{foo, bar} {
+		if err := applyCRD(crds, crd); err != nil {
+			return err
+		}
+		if err := waitForCRDReady(crdClient, crd.GetName(), timeout); err != nil {
+			return err
+		}
+		fmt.Printf("%s established\n", crd.GetName())
+	}
+	return nil
+}
+
+func decodeUnstructured(data []byte) (*unstructured.Unstructured, error) {
+	u := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// applyCRD creates or updates crd. The dynamic client is used so that fields
+// newer than the vendored apiextensions types, e.g. the webhook service port,
+// are not dropped. Validation and pruning are left alone on update because
+// benchmarks toggle them at runtime, and a CRD only prunes with a schema.
+func applyCRD(client dynamic.ResourceInterface, crd *unstructured.Unstructured) error {
+	existing, err := client.Get(crd.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(crd, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	crd = crd.DeepCopy()
+	crd.SetResourceVersion(existing.GetResourceVersion())
+	for _, field := range []string{"validation", "preserveUnknownFields"} {
+		value, found, err := unstructured.NestedFieldCopy(existing.Object, "spec", field)
+		if err != nil {
+			return err
+		}
+		unstructured.RemoveNestedField(crd.Object, "spec", field)
+		if found {
+			if err := unstructured.SetNestedField(crd.Object, value, "spec", field); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = client.Update(crd, metav1.UpdateOptions{})
+	return err
+}
+
+// applyWebhook creates the webhook pod and service, with profiling if set. A
+// pod running a different image or with different arguments is replaced, and
+// the ports and selector of an existing service are updated.
+func applyWebhook(clientset *kubernetes.Clientset, image string, profiling bool, timeout time.Duration) error {
+	pod := &v1.Pod{}
+	if err := yaml.Unmarshal(webhookPod, pod); err != nil {
+		return err
+	}
+	pod.Spec.Containers[0].Image = image
+	if profiling {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, "--profiling")
+	}
+	pods := clientset.CoreV1().Pods(webhookNamespace)
+	existing, err := pods.Get(webhookPodName, metav1.GetOptions{})
+	switch {
+	case err == nil && existing.Spec.Containers[0].Image == image && apiequality.Semantic.DeepEqual(existing.Spec.Containers[0].Args, pod.Spec.Containers[0].Args):
+	case err == nil:
+		if err := pods.Delete(webhookPodName, &metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		err := wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+			_, err := pods.Get(webhookPodName, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to replace webhook pod: %v", err)
+		}
+		if _, err := pods.Create(pod); err != nil {
+			return err
+		}
+	case errors.IsNotFound(err):
+		if _, err := pods.Create(pod); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	service := &v1.Service{}
+	if err := yaml.Unmarshal(webhookService, service); err != nil {
+		return err
+	}
+	services := clientset.CoreV1().Services(webhookNamespace)
+	existingService, err := services.Get(webhookServiceName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = services.Create(service)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	// e.g. services created before admission webhooks were added only have
+	// the conversion port
+	if apiequality.Semantic.DeepEqual(existingService.Spec.Ports, service.Spec.Ports) &&
+		apiequality.Semantic.DeepEqual(existingService.Spec.Selector, service.Spec.Selector) {
+		return nil
+	}
+	existingService.Spec.Ports = service.Spec.Ports
+	existingService.Spec.Selector = service.Spec.Selector
+	_, err = services.Update(existingService)
+	return err
+}
+
+// waitForEndpoints waits until given service has a ready endpoint address
+func waitForEndpoints(clientset *kubernetes.Clientset, namespace, name string, timeout time.Duration) error {
+	err := wait.PollImmediate(readyPollInterval, timeout, func() (bool, error) {
+		ep, err := clientset.CoreV1().Endpoints(namespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, subset := range ep.Subsets {
+			if len(subset.Addresses) > 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s has no ready endpoints: %v", name, err)
+	}
+	return nil
+}
@@ -1,48 +1,19 @@
 #!/bin/bash
 
-# NOTE: this is a direct copy of README.md and meant to be up-to-dated
-# accordingly
+# NOTE: this follows README.md and is meant to be up-to-dated accordingly.
+# Steps 3 to 8 are done by the setup command instead of kubectl.
 
 # 1. Create a GCE cluster with CustomResourceWebhookConversion feature enabled
 
 MASTER_SIZE=n1-standard-8 KUBE_FEATURE_GATES="ExperimentalCriticalPodAnnotation=true,CustomResourceWebhookConversion=true" KUBE_UP_AUTOMATIC_CLEANUP=true KUBE_APISERVER_REQUEST_TIMEOUT_SEC=600 ENABLE_APISERVER_INSECURE_PORT=true $GOPATH/src/k8s.io/kubernetes/cluster/kube-up.sh
 
-# 2. Create a secret containing a TLS key and certificate
+# 2. Create a self-signed CA and a serving certificate for the webhook service,
+# and store them in a secret
 
-hack/webhook-create-signed-cert.sh \
-    --service webhook-service.default.svc \
-    --secret webhook-tls-certs \
-    --namespace default
+go run . certs --service webhook-service --namespace default --secret webhook-tls-certs
 
-# 3. Create a CRD with the caBundle correctly configured from the TLS certificate
+# 3-8. Create the CRDs, the conversion webhook and the test namespaces, waiting
+# for each of them to become ready. WEBHOOK_IMAGE is built by
+# `make push_webhook_image`.
 
-cat artifacts/crd-template.yaml | hack/webhook-patch-ca-bundle.sh --secret webhook-tls-certs > artifacts/crd-with-webhook.yaml
-kubectl apply -f artifacts/crd-with-webhook.yaml
-
-# 4. Create a conversion webhook that uses the TLS certificate and key
-
-kubectl apply -f artifacts/webhook-pod.yaml
-kubectl apply -f artifacts/webhook-service.yaml
-
-# Wait a few seconds for endpoints to be available for service
-sleep 10
-
-# 5. Create custom resources at both supported versions for the CRD
-
-kubectl apply -f artifacts/foov1.yaml
-kubectl apply -f artifacts/foov2.yaml
-
-# 6. Read using both versions
-
-kubectl get foo foov1
-kubectl get foo.v1.stable.example.com foov1
-
-# 7. Create CRD without conversion
-
-kubectl apply -f artifacts/bar-crd.yaml
-
-# 8. Create test namespaces
-
-kubectl create ns empty
-kubectl create ns large-data
-kubectl create ns large-metadata
+go run . setup --webhook-image="${WEBHOOK_IMAGE}"
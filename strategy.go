@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	clientv1beta1 "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/dynamic"
+)
+
+// webhookConfigAnnotation keeps the webhook client config of a CRD whose
+// strategy was switched to None, so it can be switched back later
+const webhookConfigAnnotation = "stable.example.com/webhook-client-config"
+
+// runStrategy toggles the conversion strategy of a CRD between benchmark runs
+func runStrategy(args []string) {
+	fs := flag.NewFlagSet("strategy", flag.ExitOnError)
+	name := fs.String("crd", fooName, "name of the Foo CRD, or copy of it, to update")
+	strategy := fs.String("strategy", string(v1beta1.WebhookConverter), "conversion strategy to set, None or Webhook")
+	fs.DurationVar(crdReadyTimeout, "crd-ready-timeout", *crdReadyTimeout, "how long to wait for the conversion strategy change to take effect")
+	fs.Parse(args)
+
+	if err := ensureConversionStrategy(*name, v1beta1.ConversionStrategyType(*strategy)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s uses conversion strategy %s\n", *name, *strategy)
+}
+
+// setupStrategyCRDs makes sure the FooNone and FooWebhook CRDs exist as copies
+// of the Foo CRD, so that a comparison between them only measures the
+// conversion path
+func setupStrategyCRDs() error {
+	client, err := newCRDClient()
+	if err != nil {
+		return err
+	}
+	if err := ensureFooCopy(client, fooNoneName, "FooNone", "foonones", v1beta1.NoneConverter); err != nil {
+		return err
+	}
+	return ensureFooCopy(client, fooWebhookName, "FooWebhook", "foowebhooks", v1beta1.WebhookConverter)
+}
+
+// ensureFooCopy makes sure given CRD has the Foo CRD spec under different
+// names, with given conversion strategy. The spec is copied as unstructured,
+// so that fields newer than the vendored apiextensions types are copied too.
+func ensureFooCopy(client clientv1beta1.CustomResourceDefinitionInterface, name, kind, plural string, strategy v1beta1.ConversionStrategyType) error {
+	crds, foo, err := getCRD(fooName)
+	if err != nil {
+		return err
+	}
+	_, err = crds.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		spec, _, err := unstructured.NestedMap(foo.Object, "spec")
+		if err != nil {
+			return err
+		}
+		crd := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": foo.GetAPIVersion(),
+			"kind":       foo.GetKind(),
+			"spec":       spec,
+		}}
+		crd.SetName(name)
+		spec["names"] = map[string]interface{}{"kind": kind, "plural": plural}
+		conversion, err := conversionFor(crds, crd, strategy)
+		if err != nil {
+			return err
+		}
+		spec["conversion"] = conversion
+		if _, err := crds.Create(crd, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create %s: %v", name, err)
+		}
+		if err := waitForCRDReady(client, name, *crdReadyTimeout); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	validation, _, err := unstructured.NestedMap(foo.Object, "spec", "validation")
+	if err != nil {
+		return err
+	}
+	preserve, _, err := unstructured.NestedBool(foo.Object, "spec", "preserveUnknownFields")
+	if err != nil {
+		return err
+	}
+	if err := ensureValidation(name, validation, !preserve, false); err != nil {
+		return err
+	}
+	return ensureConversionStrategy(name, strategy)
+}
+
+// ensureConversionStrategy makes sure given Foo CRD has expected conversion
+// strategy
+func ensureConversionStrategy(name string, strategy v1beta1.ConversionStrategyType) error {
+	client, crd, err := getCRD(name)
+	if err != nil {
+		return err
+	}
+	current, found, err := unstructured.NestedMap(crd.Object, "spec", "conversion")
+	if err != nil {
+		return err
+	}
+	if found && current["strategy"] == string(strategy) {
+		return nil
+	}
+	conversion, err := conversionFor(client, crd, strategy)
+	if err != nil {
+		return err
+	}
+	if _, hasConfig := current["webhookClientConfig"]; strategy == v1beta1.NoneConverter && hasConfig {
+		data, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		annotations := crd.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[webhookConfigAnnotation] = string(data)
+		crd.SetAnnotations(annotations)
+	}
+	if err := unstructured.SetNestedMap(crd.Object, conversion, "spec", "conversion"); err != nil {
+		return err
+	}
+	crd, err = client.Update(crd, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update conversion strategy of %s: %v", name, err)
+	}
+	typed, err := typedCRD(crd)
+	if err != nil {
+		return err
+	}
+	// as for storage versions, only the conversion probe tells when the new
+	// strategy is used
+	return waitForConversion(typed, *crdReadyTimeout)
+}
+
+// conversionFor builds the conversion settings of given strategy for crd. The
+// webhook client config is restored from the annotation left behind when crd
+// was switched to None, or else copied from the Foo CRD.
+func conversionFor(client dynamic.ResourceInterface, crd *unstructured.Unstructured, strategy v1beta1.ConversionStrategyType) (map[string]interface{}, error) {
+	switch strategy {
+	case v1beta1.NoneConverter:
+		return map[string]interface{}{"strategy": string(v1beta1.NoneConverter)}, nil
+	case v1beta1.WebhookConverter:
+	default:
+		return nil, fmt.Errorf("unsupported conversion strategy %q", strategy)
+	}
+
+	if data, ok := crd.GetAnnotations()[webhookConfigAnnotation]; ok {
+		conversion := map[string]interface{}{}
+		if err := utiljson.Unmarshal([]byte(data), &conversion); err != nil {
+			return nil, fmt.Errorf("failed to decode %s annotation of %s: %v", webhookConfigAnnotation, crd.GetName(), err)
+		}
+		return conversion, nil
+	}
+	foo, err := client.Get(fooName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	conversion, _, err := unstructured.NestedMap(foo.Object, "spec", "conversion")
+	if err != nil {
+		return nil, err
+	}
+	if _, hasConfig := conversion["webhookClientConfig"]; conversion["strategy"] != string(v1beta1.WebhookConverter) || !hasConfig {
+		return nil, fmt.Errorf("%s has no webhook conversion to copy", fooName)
+	}
+	return conversion, nil
+}
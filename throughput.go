@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	throughputObjects     = flag.Int("throughput-objects", 0, "number of objects created by throughput scenarios, b.N by default")
+	throughputConcurrency = flag.Int("throughput-concurrency", 100, "number of concurrent creates of throughput scenarios")
+	throughputClients     = flag.Int("throughput-clients", 1, "number of clients, each with its own HTTP/2 connection, the creates of throughput scenarios are spread across")
+)
+
+// newDedicatedRESTConfig is newRESTConfig with a transport of its own. Clients
+// for configs with the same TLS options otherwise share a cached transport,
+// and with it their HTTP/2 connection to the apiserver.
+func newDedicatedRESTConfig() (*rest.Config, error) {
+	config, err := newRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return nil, err
+	}
+	// like the cached transports of client-go
+	config.Transport = utilnet.SetTransportDefaults(&http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 25,
+	})
+	// the TLS options are in the transport, and can't be set with it
+	config.TLSClientConfig = rest.TLSClientConfig{}
+	return config, nil
+}
+
+// fanOutClient creates object i with client i modulo the number of clients,
+// and runs other operations with the first one
+type fanOutClient struct {
+	BenchmarkClient
+	clients []BenchmarkClient
+}
+
+var _ BenchmarkClient = &fanOutClient{}
+
+func (c *fanOutClient) Create(i int) (interface{}, error) {
+	return c.clients[i%len(c.clients)].Create(i)
+}
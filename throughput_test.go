@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// creatingClient counts the objects it creates
+type creatingClient struct {
+	BenchmarkClient
+	created []int
+}
+
+func (c *creatingClient) Create(i int) (interface{}, error) {
+	c.created = append(c.created, i)
+	return nil, nil
+}
+
+func TestFanOutClient(t *testing.T) {
+	clients := []*creatingClient{{}, {}, {}}
+	c := &fanOutClient{BenchmarkClient: clients[0]}
+	for _, client := range clients {
+		c.clients = append(c.clients, client)
+	}
+	for i := 0; i < 7; i++ {
+		if _, err := c.Create(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range [][]int{{0, 3, 6}, {1, 4}, {2, 5}} {
+		if got := clients[i].created; !reflect.DeepEqual(got, want) {
+			t.Errorf("client %d created %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamiealquiza/tachymeter"
+)
+
+var traceRequests = flag.Bool("trace-requests", false, "trace the phases of the HTTP requests of all clients, to tell apiserver processing apart from transfer and client decoding")
+
+// requestPhases are the phases of HTTP requests traced, in the order they
+// happen. DNS, connect and TLS handshake only happen on new connections.
+var requestPhases = []string{"dns", "connect", "tls handshake", "time to first byte", "body read", "request", "operation"}
+
+// requestTraces are the traces of the requests of all clients from
+// newRESTConfig since the last reset
+var requestTraces = newRequestTracer()
+
+// requestTracer collects the latency of request phases. It is safe for
+// concurrent use.
+type requestTracer struct {
+	lock   sync.Mutex
+	phases map[string]*tachymeter.Tachymeter
+	// number of traced requests, of requests on reused connections, and of
+	// response body bytes
+	requests int64
+	reused   int64
+	bytes    int64
+	// paused is 1 while requests aren't traced, updated atomically
+	paused int32
+}
+
+func newRequestTracer() *requestTracer {
+	t := &requestTracer{}
+	t.reset()
+	return t
+}
+
+// reset drops the traces so far, e.g. of requests setting up a scenario
+func (t *requestTracer) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.phases = map[string]*tachymeter.Tachymeter{}
+	for _, phase := range requestPhases {
+		t.phases[phase] = tachymeter.New(&tachymeter.Config{Size: 100000, Safe: true})
+	}
+	atomic.StoreInt64(&t.requests, 0)
+	atomic.StoreInt64(&t.reused, 0)
+	atomic.StoreInt64(&t.bytes, 0)
+}
+
+// withoutTracing runs f without tracing its requests, e.g. to prepare objects
+// between measured requests
+func (t *requestTracer) withoutTracing(f func() error) error {
+	atomic.StoreInt32(&t.paused, 1)
+	defer atomic.StoreInt32(&t.paused, 0)
+	return f()
+}
+
+func (t *requestTracer) tracing() bool {
+	return atomic.LoadInt32(&t.paused) == 0
+}
+
+func (t *requestTracer) add(phase string, d time.Duration) {
+	t.lock.Lock()
+	phases := t.phases
+	t.lock.Unlock()
+	phases[phase].AddTime(d)
+}
+
+// String reports the latency of every phase, the response sizes, and the time
+// the client spends on operations beyond their request, mostly decoding
+func (t *requestTracer) String() string {
+	t.lock.Lock()
+	phases := t.phases
+	t.lock.Unlock()
+	requests := atomic.LoadInt64(&t.requests)
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "traced %d requests, %d on reused connections", requests, atomic.LoadInt64(&t.reused))
+	if requests > 0 {
+		fmt.Fprintf(b, ", %d response bytes on average", atomic.LoadInt64(&t.bytes)/requests)
+	}
+	fmt.Fprintln(b)
+	means := map[string]time.Duration{}
+	for _, phase := range requestPhases {
+		m := phases[phase].Calc()
+		if m.Count == 0 {
+			continue
+		}
+		means[phase] = m.Time.Avg
+		fmt.Fprintf(b, "%s:\n%s\n", phase, m.String())
+	}
+	// every operation sends one request, and decodes its response after the
+	// body is read
+	if means["operation"] > 0 && means["request"] > 0 {
+		fmt.Fprintf(b, "client decode, mean operation minus mean request: %v\n", means["operation"]-means["request"])
+	}
+	return b.String()
+}
+
+// wrapTracing traces the requests of rt to requestTraces, except watches
+// whose bodies stream for as long as the watch is open, and scrapes of
+// metrics and profiles
+func wrapTracing(rt http.RoundTripper) http.RoundTripper {
+	return &tracingRoundTripper{rt: rt, tracer: requestTraces}
+}
+
+type tracingRoundTripper struct {
+	rt     http.RoundTripper
+	tracer *requestTracer
+}
+
+// untraced tells whether requests of path scrape metrics or profiles
+func untraced(path string) bool {
+	return path == "/metrics" || strings.HasPrefix(path, "/debug/pprof/") || strings.Contains(path, "/proxy/debug/pprof/")
+}
+
+// requestTrace holds the start of the phases of one request, which the
+// transport may report from other goroutines
+type requestTrace struct {
+	lock         sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	firstByte    time.Time
+}
+
+func (r *requestTrace) mark(at *time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	*at = time.Now()
+}
+
+func (r *requestTrace) since(at *time.Time) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return time.Since(*at)
+}
+
+func (rt *tracingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("watch") == "true" || untraced(req.URL.Path) || !rt.tracer.tracing() {
+		return rt.rt.RoundTrip(req)
+	}
+	r := &requestTrace{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { r.mark(&r.dnsStart) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rt.tracer.add("dns", r.since(&r.dnsStart))
+		},
+		ConnectStart: func(string, string) { r.mark(&r.connectStart) },
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				rt.tracer.add("connect", r.since(&r.connectStart))
+			}
+		},
+		TLSHandshakeStart: func() { r.mark(&r.tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				rt.tracer.add("tls handshake", r.since(&r.tlsStart))
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&rt.tracer.reused, 1)
+			}
+		},
+		GotFirstResponseByte: func() { r.mark(&r.firstByte) },
+	}
+	resp, err := rt.rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return resp, err
+	}
+	atomic.AddInt64(&rt.tracer.requests, 1)
+	r.lock.Lock()
+	if r.firstByte.IsZero() {
+		r.firstByte = time.Now()
+	}
+	firstByte := r.firstByte
+	r.lock.Unlock()
+	rt.tracer.add("time to first byte", firstByte.Sub(r.start))
+	resp.Body = &tracedBody{ReadCloser: resp.Body, tracer: rt.tracer, start: r.start, firstByte: firstByte}
+	return resp, nil
+}
+
+// tracedBody records the time from the first response byte until the body is
+// read, the request latency up to then, and the body size
+type tracedBody struct {
+	io.ReadCloser
+	tracer    *requestTracer
+	start     time.Time
+	firstByte time.Time
+	bytes     int64
+	done      sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *tracedBody) finish() {
+	b.done.Do(func() {
+		b.tracer.add("body read", time.Since(b.firstByte))
+		b.tracer.add("request", time.Since(b.start))
+		atomic.AddInt64(&b.tracer.bytes, b.bytes)
+	})
+}
+
+// tracingClient records the latency of the operations of a BenchmarkClient
+// next to the traces of their requests
+type tracingClient struct {
+	BenchmarkClient
+	tracer *requestTracer
+}
+
+var _ BenchmarkClient = &tracingClient{}
+
+func (c *tracingClient) trace(op func() (interface{}, error)) (interface{}, error) {
+	start := time.Now()
+	obj, err := op()
+	if c.tracer.tracing() {
+		c.tracer.add("operation", time.Since(start))
+	}
+	return obj, err
+}
+
+func (c *tracingClient) Create(i int) (interface{}, error) {
+	return c.trace(func() (interface{}, error) {
+		return c.BenchmarkClient.Create(i)
+	})
+}
+
+func (c *tracingClient) Update(obj interface{}) (interface{}, error) {
+	return c.trace(func() (interface{}, error) {
+		return c.BenchmarkClient.Update(obj)
+	})
+}
+
+func (c *tracingClient) Get(obj interface{}) (interface{}, error) {
+	return c.trace(func() (interface{}, error) {
+		return c.BenchmarkClient.Get(obj)
+	})
+}
+
+func (c *tracingClient) List() (interface{}, error) {
+	return c.trace(c.BenchmarkClient.List)
+}
+
+func (c *tracingClient) DeleteCollection() error {
+	_, err := c.trace(func() (interface{}, error) {
+		return nil, c.BenchmarkClient.DeleteCollection()
+	})
+	return err
+}
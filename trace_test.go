@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracingRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 1000))
+	}))
+	defer server.Close()
+
+	tracer := newRequestTracer()
+	client := &http.Client{Transport: &tracingRoundTripper{rt: &http.Transport{}, tracer: tracer}}
+	for _, path := range []string{"/api/v1/endpoints", "/api/v1/endpoints", "/metrics", "/api/v1/endpoints?watch=true"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	report := tracer.String()
+	if want := "traced 2 requests, 1 on reused connections, 1000 response bytes on average"; !strings.Contains(report, want) {
+		t.Errorf("report %q does not contain %q", report, want)
+	}
+	for phase, want := range map[string]int{"connect": 1, "time to first byte": 2, "body read": 2, "request": 2, "operation": 0} {
+		if got := tracer.phases[phase].Calc().Count; got != want {
+			t.Errorf("got %d traces of %s, want %d", got, phase, want)
+		}
+	}
+
+	tracer.reset()
+	if got := tracer.phases["request"].Calc().Count; got != 0 {
+		t.Errorf("got %d traces after reset, want none", got)
+	}
+
+	err := tracer.withoutTracing(func() error {
+		resp, err := client.Get(server.URL + "/api/v1/endpoints")
+		if err != nil {
+			return err
+		}
+		if _, err := ioutil.ReadAll(resp.Body); err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tracer.phases["request"].Calc().Count; got != 0 {
+		t.Errorf("got %d traces of requests sent without tracing, want none", got)
+	}
+}
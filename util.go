@@ -2,19 +2,21 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
+	"golang.org/x/sync/errgroup"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	clientv1beta1 "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
-	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
@@ -28,19 +30,26 @@ import (
 
 var (
 	// GVR used for building dynamic client
-	foov1GVR     = schema.GroupVersionResource{Group: "stable.example.com", Version: "v1", Resource: "foos"}
-	foov2GVR     = schema.GroupVersionResource{Group: "stable.example.com", Version: "v2", Resource: "foos"}
-	barGVR       = schema.GroupVersionResource{Group: "stable.example.com", Version: "v1", Resource: "bars"}
-	endpointsGVR = schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}
-	notfoundGVR  = schema.GroupVersionResource{Version: "error", Resource: "notfound"}
+	foov1GVR      = schema.GroupVersionResource{Group: "stable.example.com", Version: "v1", Resource: "foos"}
+	foov2GVR      = schema.GroupVersionResource{Group: "stable.example.com", Version: "v2", Resource: "foos"}
+	barGVR        = schema.GroupVersionResource{Group: "stable.example.com", Version: "v1", Resource: "bars"}
+	fooNoneGVR    = schema.GroupVersionResource{Group: "stable.example.com", Version: "v1", Resource: "foonones"}
+	fooWebhookGVR = schema.GroupVersionResource{Group: "stable.example.com", Version: "v1", Resource: "foowebhooks"}
+	endpointsGVR  = schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}
+	notfoundGVR   = schema.GroupVersionResource{Version: "error", Resource: "notfound"}
 
 	emptyNamespace         = "empty"
 	largeDataNamespace     = "large-data"
 	largeMetadataNamespace = "large-metadata"
+	migrationNamespace     = "migration"
 
 	fooName = "foos.stable.example.com"
 	barName = "bars.stable.example.com"
 
+	// copies of the Foo CRD that only differ in conversion strategy
+	fooNoneName    = "foonones.stable.example.com"
+	fooWebhookName = "foowebhooks.stable.example.com"
+
 	// size in kB
 	largeDataSize = 50
 	dummyFields   = []string{"spec", "dummy"}
@@ -48,6 +57,9 @@ var (
 
 	// number of objects we will create and list in list benchmarks
 	testListSize = 1000
+
+	// annotation changed by every update in update benchmarks
+	updateAnnotation = "stable.example.com/updated"
 )
 
 var foov1Template = []byte(`apiVersion: stable.example.com/v1
@@ -55,6 +67,21 @@ kind: Foo
 metadata:
   name: template`)
 
+var foov2Template = []byte(`apiVersion: stable.example.com/v2
+kind: Foo
+metadata:
+  name: template`)
+
+var fooNoneTemplate = []byte(`apiVersion: stable.example.com/v1
+kind: FooNone
+metadata:
+  name: template`)
+
+var fooWebhookTemplate = []byte(`apiVersion: stable.example.com/v1
+kind: FooWebhook
+metadata:
+  name: template`)
+
 var barTemplate = []byte(`apiVersion: stable.example.com/v1
 kind: Bar
 metadata:
@@ -90,14 +117,18 @@ var validationSchema = []byte(`openAPIV3Schema:
           type: object
           description: Optional Baz.`)
 
-// mustNewRESTConfig builds a rest client config
-func mustNewRESTConfig() *rest.Config {
+// newRESTConfig builds a rest client config
+func newRESTConfig() (*rest.Config, error) {
 	// TODO: add flag support in TestMain for running in master VM / remotely
 	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
+	// set by the local command for the commands it runs against its apiserver
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		kubeconfig = env
+	}
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	// config, err := clientcmd.DefaultClientConfig.ClientConfig()
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %v", kubeconfig, err)
 	}
 	// wait for long running requests, e.g. deleting 10k objects
 	config.Timeout = 10 * time.Minute
@@ -105,35 +136,69 @@ func mustNewRESTConfig() *rest.Config {
 	// increase QPS (default 5) for heavy load testing
 	config.QPS = 10000
 	config.Burst = 20000
-	return config
+
+	if *traceRequests {
+		wrap := config.WrapTransport
+		config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+			if wrap != nil {
+				rt = wrap(rt)
+			}
+			return wrapTracing(rt)
+		}
+	}
+	return config, nil
 }
 
-// mustNewDynamicClient creates a new dynamic client
-func mustNewDynamicClient() dynamic.Interface {
-	client, err := dynamic.NewForConfig(mustNewRESTConfig())
+// newDynamicClient creates a new dynamic client
+func newDynamicClient() (dynamic.Interface, error) {
+	config, err := newRESTConfig()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return client
+	return dynamic.NewForConfig(config)
 }
 
-// mustNewClientset creates a new clientset containing typed clients for groups
-func mustNewClientset() *kubernetes.Clientset {
-	client, err := kubernetes.NewForConfig(mustNewRESTConfig())
+// newClientset creates a new clientset containing typed clients for groups
+func newClientset() (*kubernetes.Clientset, error) {
+	config, err := newRESTConfig()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return client
+	return kubernetes.NewForConfig(config)
+}
+
+// newCRDClient creates a new client for CustomResourceDefinitions
+func newCRDClient() (clientv1beta1.CustomResourceDefinitionInterface, error) {
+	config, err := newRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.ApiextensionsV1beta1().CustomResourceDefinitions(), nil
 }
 
 // BenchmarkClient provides create and list interface for benchmark testing
 type BenchmarkClient interface {
-	// use i to customize and avoid race
+	// Create creates an object named by nameObject, i is the index of the
+	// object among those created by the caller
 	Create(i int) (interface{}, error)
+	// Update updates an object returned by Create or a previous Update
+	Update(obj interface{}) (interface{}, error)
+	// Get returns the current state of an object returned by Create or Update
+	Get(obj interface{}) (interface{}, error)
 	List() (interface{}, error)
 	Count() (int, error)
 	Watch() (watch.Interface, error)
+	// DeleteCollection deletes the objects created by this run
 	DeleteCollection() error
+	// Leftovers counts the objects in the namespace not created by this run
+	// selected by opts, see leftoverListOptions and unlabeledListOptions
+	Leftovers(opts metav1.ListOptions) (int, error)
+	// DeleteLeftovers deletes the objects in the namespace selected by opts
+	DeleteLeftovers(opts metav1.ListOptions) error
 }
 
 var _ BenchmarkClient = &dynamicBenchmarkClient{}
@@ -144,14 +209,33 @@ type dynamicBenchmarkClient struct {
 	client      dynamic.ResourceInterface
 	template    *unstructured.Unstructured
 	listOptions *metav1.ListOptions
+	variation   *objectVariation
 }
 
 func (c *dynamicBenchmarkClient) Create(i int) (interface{}, error) {
 	obj := c.template.DeepCopy()
-	obj.SetName(fmt.Sprintf("%d-%d", time.Now().Nanosecond(), i))
+	nameObject(obj)
+	if c.variation != nil {
+		c.variation.varyUnstructured(obj)
+	}
 	return c.client.Create(obj, metav1.CreateOptions{})
 }
 
+func (c *dynamicBenchmarkClient) Update(obj interface{}) (interface{}, error) {
+	u := obj.(*unstructured.Unstructured).DeepCopy()
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[updateAnnotation] = fmt.Sprint(time.Now().UnixNano())
+	u.SetAnnotations(annotations)
+	return c.client.Update(u, metav1.UpdateOptions{})
+}
+
+func (c *dynamicBenchmarkClient) Get(obj interface{}) (interface{}, error) {
+	return c.client.Get(obj.(*unstructured.Unstructured).GetName(), metav1.GetOptions{})
+}
+
 func (c *dynamicBenchmarkClient) List() (interface{}, error) {
 	return c.client.List(*c.listOptions)
 }
@@ -169,7 +253,19 @@ func (c *dynamicBenchmarkClient) Watch() (watch.Interface, error) {
 }
 
 func (c *dynamicBenchmarkClient) DeleteCollection() error {
-	return c.client.DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{})
+	return c.client.DeleteCollection(&metav1.DeleteOptions{}, runListOptions())
+}
+
+func (c *dynamicBenchmarkClient) Leftovers(opts metav1.ListOptions) (int, error) {
+	l, err := c.client.List(opts)
+	if err != nil {
+		return 0, err
+	}
+	return len(l.Items), nil
+}
+
+func (c *dynamicBenchmarkClient) DeleteLeftovers(opts metav1.ListOptions) error {
+	return c.client.DeleteCollection(&metav1.DeleteOptions{}, opts)
 }
 
 // endpointsBenchmarkClient implements BenchmarkClient interface
@@ -177,14 +273,28 @@ type endpointsBenchmarkClient struct {
 	client      clientv1.EndpointsInterface
 	template    *v1.Endpoints
 	listOptions *metav1.ListOptions
+	variation   *objectVariation
 }
 
 func (c *endpointsBenchmarkClient) Create(i int) (interface{}, error) {
 	obj := c.template.DeepCopy()
-	obj.SetName(fmt.Sprintf("%d-%d", time.Now().Nanosecond(), i))
+	nameObject(obj)
+	if c.variation != nil {
+		c.variation.varyEndpoints(obj)
+	}
 	return c.client.Create(obj)
 }
 
+func (c *endpointsBenchmarkClient) Update(obj interface{}) (interface{}, error) {
+	e := obj.(*v1.Endpoints).DeepCopy()
+	metav1.SetMetaDataAnnotation(&e.ObjectMeta, updateAnnotation, fmt.Sprint(time.Now().UnixNano()))
+	return c.client.Update(e)
+}
+
+func (c *endpointsBenchmarkClient) Get(obj interface{}) (interface{}, error) {
+	return c.client.Get(obj.(*v1.Endpoints).Name, metav1.GetOptions{})
+}
+
 func (c *endpointsBenchmarkClient) List() (interface{}, error) {
 	return c.client.List(*c.listOptions)
 }
@@ -203,113 +313,277 @@ func (c *endpointsBenchmarkClient) Watch() (watch.Interface, error) {
 }
 
 func (c *endpointsBenchmarkClient) DeleteCollection() error {
-	return c.client.DeleteCollection(&metav1.DeleteOptions{}, metav1.ListOptions{})
+	return c.client.DeleteCollection(&metav1.DeleteOptions{}, runListOptions())
+}
+
+func (c *endpointsBenchmarkClient) Leftovers(opts metav1.ListOptions) (int, error) {
+	l, err := c.client.List(opts)
+	if err != nil {
+		return 0, err
+	}
+	return len(l.Items), nil
 }
 
-func mustNewDynamicBenchmarkClient(gvr schema.GroupVersionResource, namespace string,
-	templateData []byte, listOptions *metav1.ListOptions) BenchmarkClient {
+func (c *endpointsBenchmarkClient) DeleteLeftovers(opts metav1.ListOptions) error {
+	return c.client.DeleteCollection(&metav1.DeleteOptions{}, opts)
+}
+
+// newDynamicBenchmarkClient creates objects from templateData, varied per
+// object unless variation is nil, with a client for config
+func newDynamicBenchmarkClient(config *rest.Config, gvr schema.GroupVersionResource, namespace string,
+	templateData []byte, listOptions *metav1.ListOptions, variation *objectVariation) (BenchmarkClient, error) {
 	template := unstructured.Unstructured{}
 	if err := yaml.Unmarshal(templateData, &template); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to decode template: %v", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
 	}
 	return &dynamicBenchmarkClient{
-		client:      mustNewDynamicClient().Resource(gvr).Namespace(namespace),
+		client:      client.Resource(gvr).Namespace(namespace),
 		template:    &template,
 		listOptions: listOptions,
-	}
+		variation:   variation,
+	}, nil
 }
 
-func mustNewEndpointsBenchmarkClient(namespace string, templateData []byte,
-	listOptions *metav1.ListOptions) BenchmarkClient {
+// newEndpointsBenchmarkClient creates objects from templateData, varied per
+// object unless variation is nil, with a client for config
+func newEndpointsBenchmarkClient(config *rest.Config, namespace string, templateData []byte,
+	listOptions *metav1.ListOptions, variation *objectVariation) (BenchmarkClient, error) {
 	template := v1.Endpoints{}
 	if err := yaml.Unmarshal(templateData, &template); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to decode template: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
 	}
 	return &endpointsBenchmarkClient{
-		client:      mustNewClientset().CoreV1().Endpoints(namespace),
+		client:      clientset.CoreV1().Endpoints(namespace),
 		template:    &template,
 		listOptions: listOptions,
-	}
+		variation:   variation,
+	}, nil
 }
 
-// mustIncreaseObjectSize bumps data by kB size
-func mustIncreaseObjectSize(data []byte, size int, fields ...string) []byte {
+// increaseObjectSize pads fields of data until the object is at least size kB
+// as JSON, with 1kB annotations if fields are in metadata, or 1kB items of the
+// dummy array otherwise
+func increaseObjectSize(data []byte, size int, fields ...string) ([]byte, error) {
 	u := unstructured.Unstructured{}
 	if err := yaml.Unmarshal(data, &u); err != nil {
-		panic(err)
+		return nil, err
 	}
 	// NOTE: we are have a rough equivalence in size between annotation and CR array,
 	// because there is no good array candidate in metadata
+	var add func(i int)
 	if fields[0] == "metadata" {
-		dummy := map[string]string{}
-		for i := 0; i < size; i++ {
-			// TODO: double check wired format size
+		dummy := map[string]interface{}{}
+		if err := unstructured.SetNestedMap(u.Object, dummy, fields...); err != nil {
+			return nil, err
+		}
+		value, _, _ := unstructured.NestedFieldNoCopy(u.Object, fields...)
+		dummy = value.(map[string]interface{})
+		add = func(i int) {
 			// 1000 bytes each in JSON (10+6+984=1000)
 			//     ,"<10 bytes>":"<984 bytes>"
 			dummy[fmt.Sprintf("%010d", i)] = strings.Repeat("x", 984)
 		}
-		if err := unstructured.SetNestedStringMap(u.Object, dummy, fields...); err != nil {
-			panic(err)
-		}
 	} else {
-		// TODO: unstructured doesn't support set (deep copy) nested struct
-		dummy := []string{}
-		for i := 0; i < size; i++ {
-			// TODO: double check wired format size
+		// the slice grows, so it is set again after every item
+		dummy := []interface{}{}
+		add = func(i int) {
 			// 1000 bytes each in JSON (9+991=1000)
 			//     ,"dummy-<991 bytes>"
 			dummy = append(dummy, fmt.Sprintf("dummy-%0991d", i))
+			spec, _, _ := unstructured.NestedFieldNoCopy(u.Object, fields[:len(fields)-1]...)
+			spec.(map[string]interface{})[fields[len(fields)-1]] = dummy
 		}
-		if err := unstructured.SetNestedStringSlice(u.Object, dummy, fields...); err != nil {
-			panic(err)
+		if _, found, _ := unstructured.NestedFieldNoCopy(u.Object, fields[:len(fields)-1]...); !found {
+			if err := unstructured.SetNestedMap(u.Object, map[string]interface{}{}, fields[:len(fields)-1]...); err != nil {
+				return nil, err
+			}
 		}
 	}
-	d, err := yaml.Marshal(&u)
-	if err != nil {
-		panic(err)
+	if _, err := fillToSize(&u, size*1000, add); err != nil {
+		return nil, err
 	}
-	return d
+	return yaml.Marshal(&u)
 }
 
 func getGVR(name string) schema.GroupVersionResource {
+	if _, read, ok := chainScenario(name); ok {
+		return chainGVR(read)
+	}
 	if strings.Contains(name, "CRWithConvert") {
 		return foov1GVR
 	}
+	if strings.Contains(name, "CRStrategyNone") {
+		return fooNoneGVR
+	}
+	if strings.Contains(name, "CRStrategyWebhook") {
+		return fooWebhookGVR
+	}
 	if strings.Contains(name, "CR") {
 		return barGVR
 	}
-	if strings.Contains(name, "Endpoints") && strings.Contains(name, "Dynamic") {
+	if strings.Contains(name, "Endpoints") && (strings.Contains(name, "Dynamic") || strings.Contains(name, "Raw")) {
 		return endpointsGVR
 	}
 	return notfoundGVR
 }
 
+// getNamespace returns the namespace of a scenario. Scenarios whose objects
+// differ are kept apart, so a list only counts objects of one kind.
 func getNamespace(name string) string {
-	if strings.Contains(name, "LargeData") {
-		return largeDataNamespace
+	namespace := emptyNamespace
+	size, sized := sizeScenario(name)
+	switch {
+	case getPayloadShape(name) != "":
+		if !sized {
+			size = largeDataSize
+		}
+		namespace = payloadNamespace(getPayloadShape(name), size)
+	case sized:
+		namespace = sizeNamespace(size)
+	case strings.Contains(name, "LargeData"):
+		namespace = largeDataNamespace
+	case strings.Contains(name, "LargeMetadata"):
+		namespace = largeMetadataNamespace
 	}
-	if strings.Contains(name, "LargeMetadata") {
-		return largeMetadataNamespace
+	if getVariation(name) != nil {
+		namespace += "-varied"
 	}
-	return emptyNamespace
+	return namespace
 }
 
-func getTemplate(name string) []byte {
+func getTemplate(name string) ([]byte, error) {
 	var template []byte
-	if strings.Contains(name, "CRWithConvert") {
+	endpoints := false
+	if _, read, ok := chainScenario(name); ok {
+		template = chainTemplate(read)
+	} else if strings.Contains(name, "CRWithConvert") {
 		template = foov1Template
+	} else if strings.Contains(name, "CRStrategyNone") {
+		template = fooNoneTemplate
+	} else if strings.Contains(name, "CRStrategyWebhook") {
+		template = fooWebhookTemplate
 	} else if strings.Contains(name, "CR") {
 		template = barTemplate
 	} else {
 		template = endpointsTemplate
+		endpoints = true
 	}
 
-	if strings.Contains(name, "LargeData") {
-		template = mustIncreaseObjectSize(template, largeDataSize, dummyFields...)
+	size, sized := sizeScenario(name)
+	if !sized {
+		size = largeDataSize
+	}
+	var err error
+	if shape := getPayloadShape(name); shape != "" {
+		template, err = generatePayload(template, shape, size*1000)
+	} else if sized && endpoints {
+		template, err = addEndpointsAddresses(template, size)
+	} else if sized {
+		template, err = increaseObjectSize(template, size, dummyFields...)
+	} else if strings.Contains(name, "LargeData") {
+		template, err = increaseObjectSize(template, largeDataSize, dummyFields...)
 	} else if strings.Contains(name, "LargeMetadata") {
-		template = mustIncreaseObjectSize(template, largeDataSize, metaFields...)
+		template, err = increaseObjectSize(template, largeDataSize, metaFields...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to build template of %s: %v", name, err)
+	}
+	return addProfileSpec(template, getValidationProfile(name))
+}
+
+// unsupportedError tells that the cluster can't run a scenario, which is
+// skipped rather than failed
+type unsupportedError struct {
+	reason string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.reason
+}
+
+// setupScenario prepares the cluster for the named scenario: its namespaces,
+// the validation of the Foo and Bar CRDs, admission webhooks, and the CRDs
+// only some scenarios use. It returns an unsupportedError if the cluster can't
+// run the scenario.
+func setupScenario(name string) error {
+	// TODO: this is a workaround for go-benchmark not supporting before-benchmark setup
+	for _, namespace := range []string{emptyNamespace, largeDataNamespace, largeMetadataNamespace, getNamespace(name)} {
+		if err := setupNamespace(namespace); err != nil {
+			return err
+		}
+	}
+	if err := setupValidation(getValidationProfile(name)); err != nil {
+		return err
+	}
+	if err := setupAdmission(admissionScenario(name)); err != nil {
+		return err
+	}
+	if strings.Contains(name, "CRStrategy") {
+		if err := setupStrategyCRDs(); err != nil {
+			return err
+		}
+	}
+	if versions, _, ok := chainScenario(name); ok {
+		return setupChainCRD(versions)
 	}
-	return template
+	return nil
+}
+
+// newScenarioClient creates the BenchmarkClient of the named scenario, which
+// creates objects from template, and retries by the retry policy of the
+// scenario. With --throughput-clients above 1, created objects are spread
+// across as many clients, each with its own connection.
+func newScenarioClient(name string, template []byte) (BenchmarkClient, error) {
+	policy, err := getRetryPolicy(name)
+	if err != nil {
+		return nil, err
+	}
+	// shared by all clients, so that no two of them create the same variant
+	variation := getVariation(name)
+	var clients []BenchmarkClient
+	for i := 0; i < *throughputClients || i == 0; i++ {
+		var config *rest.Config
+		if *throughputClients > 1 {
+			config, err = newDedicatedRESTConfig()
+		} else {
+			config, err = newRESTConfig()
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c BenchmarkClient
+		if strings.Contains(name, "Typed") {
+			c, err = newEndpointsBenchmarkClient(config, getNamespace(name), template, getListOptions(name), variation)
+		} else if strings.Contains(name, "Raw") {
+			c, err = newRawBenchmarkClient(config, getGVR(name), getNamespace(name), template, getListOptions(name), variation)
+		} else {
+			c, err = newDynamicBenchmarkClient(config, getGVR(name), getNamespace(name), template, getListOptions(name), variation)
+		}
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	c := clients[0]
+	if len(clients) > 1 {
+		c = &fanOutClient{BenchmarkClient: clients[0], clients: clients}
+	}
+	if *traceRequests {
+		// inside the retries, so every operation traced sends one request
+		c = &tracingClient{BenchmarkClient: c, tracer: requestTraces}
+	}
+	if policy.attempts <= 1 {
+		return c, nil
+	}
+	return newRetryingClient(c, policy), nil
 }
 
 func getListOptions(name string) *metav1.ListOptions {
@@ -319,57 +593,122 @@ func getListOptions(name string) *metav1.ListOptions {
 	return &metav1.ListOptions{}
 }
 
-func setupNamespace(name string) {
-	c := mustNewClientset().CoreV1().Namespaces()
-	_, err := c.Get(name, metav1.GetOptions{})
-	if err == nil {
-		return
+func setupNamespace(name string) error {
+	clientset, err := newClientset()
+	if err != nil {
+		return err
 	}
+	c := clientset.CoreV1().Namespaces()
+	_, err = c.Get(name, metav1.GetOptions{})
 	if errors.IsNotFound(err) {
-		if _, err := c.Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}); err != nil {
-			panic(err)
-		}
-		// wait for namespace to be initialized
-		time.Sleep(10 * time.Second)
-	} else {
-		panic(err)
+		_, err = c.Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
 	}
+	if err != nil {
+		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+	}
+	return waitForNamespaceReady(clientset, name, *namespaceReadyTimeout)
 }
 
-func setupValidation(enable bool) {
-	clientset, err := apiextensionsclientset.NewForConfig(mustNewRESTConfig())
-	if err != nil {
+// mustNewValidation decodes validationSchema
+func mustNewValidation() *v1beta1.CustomResourceValidation {
+	v := v1beta1.CustomResourceValidation{}
+	if err := yaml.Unmarshal(validationSchema, &v); err != nil {
 		panic(err)
 	}
-	client := clientset.ApiextensionsV1beta1().CustomResourceDefinitions()
-	if enable {
-		v := v1beta1.CustomResourceValidation{}
-		if err := yaml.Unmarshal(validationSchema, &v); err != nil {
-			panic(err)
+	return &v
+}
+
+// setupValidation sets the validation of given profile on the Foo and Bar CRDs,
+// or unsets it if profile is empty, together with the pruning of the profile
+func setupValidation(profile string) error {
+	var v map[string]interface{}
+	if profile != "" {
+		var err error
+		if v, err = newValidationObject(profile); err != nil {
+			return err
 		}
-		mustHaveValidation(client, fooName, &v)
-		mustHaveValidation(client, barName, &v)
-	} else {
-		mustHaveValidation(client, fooName, nil)
-		mustHaveValidation(client, barName, nil)
 	}
+	prune, required := profilePruning(profile)
+	for _, name := range []string{fooName, barName} {
+		if err := ensureValidation(name, v, prune, required); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// mustHaveValidation makes sure given CRD has expected validation set / unset
-func mustHaveValidation(client clientv1beta1.CustomResourceDefinitionInterface, name string, validation *v1beta1.CustomResourceValidation) {
+// getCRD gets the CRD name with the dynamic client. CRD specs are only updated
+// as unstructured, as the vendored apiextensions types lack newer fields, e.g.
+// preserveUnknownFields, which a typed update would drop, so that the apiserver
+// defaults them again.
+func getCRD(name string) (dynamic.ResourceInterface, *unstructured.Unstructured, error) {
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	client := dynamicClient.Resource(crdGVR)
 	crd, err := client.Get(name, metav1.GetOptions{})
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	if apiequality.Semantic.DeepEqual(validation, crd.Spec.Validation) {
-		return
+	return client, crd, nil
+}
+
+// typedCRD converts crd to the vendored apiextensions types, without the fields
+// they lack, for reading it
+func typedCRD(crd *unstructured.Unstructured) (*v1beta1.CustomResourceDefinition, error) {
+	typed := &v1beta1.CustomResourceDefinition{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(crd.Object, typed); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", crd.GetName(), err)
 	}
-	crd.Spec.Validation = validation
-	if _, err := client.Update(crd); err != nil {
-		panic(err)
+	return typed, nil
+}
+
+// ensureStorageVersion makes sure given Foo CRD stores objects at the given
+// version
+func ensureStorageVersion(name, version string) error {
+	client, crd, err := getCRD(name)
+	if err != nil {
+		return err
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return err
+	}
+	changed := false
+	found := false
+	for _, v := range versions {
+		v, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		storage := v["name"] == version
+		found = found || storage
+		if v["storage"] != storage {
+			v["storage"] = storage
+			changed = true
+		}
 	}
-	// wait for potential initialization
-	time.Sleep(5 * time.Second)
+	if !found {
+		return fmt.Errorf("CRD %s has no version %q", name, version)
+	}
+	if !changed {
+		return nil
+	}
+	if err := unstructured.SetNestedSlice(crd.Object, versions, "spec", "versions"); err != nil {
+		return err
+	}
+	crd, err = client.Update(crd, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update storage version of %s: %v", name, err)
+	}
+	typed, err := typedCRD(crd)
+	if err != nil {
+		return err
+	}
+	// the CRD conditions don't change with the storage version, only the
+	// conversion probe tells when the new one is used
+	return waitForConversion(typed, *crdReadyTimeout)
 }
 
 func ensureObjectCount(client BenchmarkClient, listSize int) error {
@@ -378,24 +717,26 @@ func ensureObjectCount(client BenchmarkClient, listSize int) error {
 		return fmt.Errorf("failed to check list size: %v", err)
 	}
 	if num < listSize {
-		var wg sync.WaitGroup
+		var g errgroup.Group
 		remaining := listSize - num
-		wg.Add(remaining)
 		for i := 0; i < remaining; i++ {
 			// deep copy i
 			idx := i
-			go func() {
-				_, err := client.Create(idx)
-				if err != nil {
-					// TODO: b.Fatal doesn't raise
-					// b.Fatalf("failed to create object: %v", err)
-					panic(err)
+			g.Go(func() error {
+				if _, err := client.Create(idx); err != nil {
+					return fmt.Errorf("failed to create object %d of %d: %v", idx, remaining, err)
 				}
-				wg.Done()
-			}()
+				return nil
+			})
 		}
-		wg.Wait()
+		return g.Wait()
 	} else if num > listSize {
+		// lists return every object in the namespace, so objects of earlier
+		// runs are counted too, checkLeftovers fails before running on them
+		leftovers, err := client.Leftovers(leftoverListOptions())
+		if err == nil && leftovers > 0 {
+			return fmt.Errorf("Too many items already exist. Want %d got %d, %d of them left by earlier runs, run with --purge-leftovers to delete them", listSize, num, leftovers)
+		}
 		return fmt.Errorf("Too many items already exist. Want %d got %d", listSize, num)
 	}
 	return nil
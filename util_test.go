@@ -0,0 +1,600 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "test"
+
+// the kind the fake dynamic client lists unstructured objects as
+var dynamicListKind = schema.GroupVersionKind{Group: "fake-dynamic-client-group", Version: "v1", Kind: ""}
+
+// withRunID runs f as a run with the given ID, with a fresh object sequence
+func withRunID(id string, f func()) {
+	oldID, oldSequence := *runID, objectSequence
+	defer func() { *runID, objectSequence = oldID, oldSequence }()
+	*runID, objectSequence = id, 0
+	f()
+}
+
+// trackObjects makes fakeClient store objects in tracker, and supports
+// DeleteCollection, which the fake clients don't implement
+func trackObjects(fakeClient *clienttesting.Fake, tracker clienttesting.ObjectTracker, listKind schema.GroupVersionKind) {
+	fakeClient.PrependReactor("delete-collection", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		selector := action.(clienttesting.DeleteCollectionAction).GetListRestrictions().Labels
+		list, err := tracker.List(action.GetResource(), listKind, action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return true, nil, err
+		}
+		for _, item := range items {
+			m, err := meta.Accessor(item)
+			if err != nil {
+				return true, nil, err
+			}
+			if !selector.Matches(labels.Set(m.GetLabels())) {
+				continue
+			}
+			if err := tracker.Delete(action.GetResource(), action.GetNamespace(), m.GetName()); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, nil
+	})
+	fakeClient.PrependReactor("*", "*", clienttesting.ObjectReaction(tracker))
+}
+
+func newFakeDynamicBenchmarkClient(t *testing.T, templateData []byte, variation *objectVariation) *dynamicBenchmarkClient {
+	s := runtime.NewScheme()
+	client := dynamicfake.NewSimpleDynamicClient(s)
+	tracker := clienttesting.NewObjectTracker(s, serializer.NewCodecFactory(s).UniversalDecoder())
+	trackObjects(&client.Fake, tracker, dynamicListKind)
+
+	template := unstructured.Unstructured{}
+	if err := yaml.Unmarshal(templateData, &template); err != nil {
+		t.Fatal(err)
+	}
+	return &dynamicBenchmarkClient{
+		client:      client.Resource(barGVR).Namespace(testNamespace),
+		template:    &template,
+		listOptions: &metav1.ListOptions{},
+		variation:   variation,
+	}
+}
+
+func newFakeEndpointsBenchmarkClient(t *testing.T, templateData []byte, variation *objectVariation) *endpointsBenchmarkClient {
+	clientset := fake.NewSimpleClientset()
+	tracker := clienttesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	trackObjects(&clientset.Fake, tracker, v1.SchemeGroupVersion.WithKind("Endpoints"))
+
+	template := v1.Endpoints{}
+	if err := yaml.Unmarshal(templateData, &template); err != nil {
+		t.Fatal(err)
+	}
+	return &endpointsBenchmarkClient{
+		client:      clientset.CoreV1().Endpoints(testNamespace),
+		template:    &template,
+		listOptions: &metav1.ListOptions{},
+		variation:   variation,
+	}
+}
+
+// fakeBenchmarkClients returns a BenchmarkClient of each implementation,
+// backed by fake clientsets
+func fakeBenchmarkClients(t *testing.T) map[string]BenchmarkClient {
+	return map[string]BenchmarkClient{
+		"dynamic":   newFakeDynamicBenchmarkClient(t, barTemplate, nil),
+		"endpoints": newFakeEndpointsBenchmarkClient(t, endpointsTemplate, nil),
+	}
+}
+
+func mustCount(t *testing.T, c BenchmarkClient) int {
+	n, err := c.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func mustGetTemplate(t *testing.T, name string) []byte {
+	template, err := getTemplate(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return template
+}
+
+func mustLeftovers(t *testing.T, c BenchmarkClient) int {
+	n, err := c.Leftovers(leftoverListOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCreateNamesObjects(t *testing.T) {
+	for name, c := range fakeBenchmarkClients(t) {
+		withRunID("run1", func() {
+			for i := 0; i < 3; i++ {
+				obj, err := c.Create(i)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				m, err := meta.Accessor(obj)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := fmt.Sprintf("run1-%d", i+1); m.GetName() != want {
+					t.Errorf("%s: got name %q, want %q", name, m.GetName(), want)
+				}
+				if got := m.GetLabels()[runIDLabel]; got != "run1" {
+					t.Errorf("%s: got run ID label %q, want %q", name, got, "run1")
+				}
+			}
+		})
+	}
+}
+
+func TestNameObjectGenerateName(t *testing.T) {
+	defer func(old bool) { *generateName = old }(*generateName)
+	*generateName = true
+	withRunID("run1", func() {
+		obj := &v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "template", Labels: map[string]string{"app": "test"}}}
+		nameObject(obj)
+		if obj.Name != "" || obj.GenerateName != "run1-" {
+			t.Errorf("got name %q and generateName %q, want generateName %q", obj.Name, obj.GenerateName, "run1-")
+		}
+		if obj.Labels["app"] != "test" || obj.Labels[runIDLabel] != "run1" {
+			t.Errorf("got labels %v, want the template labels and the run ID", obj.Labels)
+		}
+	})
+}
+
+func TestUpdateAnnotatesObjects(t *testing.T) {
+	for name, c := range fakeBenchmarkClients(t) {
+		withRunID("run1", func() {
+			obj, err := c.Create(0)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			updated, err := c.Update(obj)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			m, err := meta.Accessor(updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := m.GetAnnotations()[updateAnnotation]; !ok {
+				t.Errorf("%s: updated object has no %s annotation", name, updateAnnotation)
+			}
+		})
+	}
+}
+
+func TestEnsureObjectCount(t *testing.T) {
+	for name, c := range fakeBenchmarkClients(t) {
+		withRunID("run1", func() {
+			for i := 0; i < 3; i++ {
+				if _, err := c.Create(i); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+			}
+			if err := ensureObjectCount(c, 10); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if n := mustCount(t, c); n != 10 {
+				t.Errorf("%s: got %d objects after topping up, want 10", name, n)
+			}
+			// a full namespace is left as is
+			if err := ensureObjectCount(c, 10); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if n := mustCount(t, c); n != 10 {
+				t.Errorf("%s: got %d objects, want 10", name, n)
+			}
+			err := ensureObjectCount(c, 5)
+			if err == nil || !strings.Contains(err.Error(), "Too many items") {
+				t.Errorf("%s: got error %v for too many objects, want Too many items", name, err)
+			}
+			if n := mustCount(t, c); n != 10 {
+				t.Errorf("%s: got %d objects after overflow, want 10 left as is", name, n)
+			}
+		})
+	}
+}
+
+func TestEnsureObjectCountLeftovers(t *testing.T) {
+	c := newFakeDynamicBenchmarkClient(t, barTemplate, nil)
+	withRunID("earlier", func() {
+		for i := 0; i < 3; i++ {
+			if _, err := c.Create(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	withRunID("run1", func() {
+		err := ensureObjectCount(c, 2)
+		if err == nil || !strings.Contains(err.Error(), "3 of them left by earlier runs") || !strings.Contains(err.Error(), "--purge-leftovers") {
+			t.Errorf("got error %v, want the leftovers and --purge-leftovers", err)
+		}
+	})
+}
+
+func TestEnsureObjectCountCreateError(t *testing.T) {
+	c := newFakeDynamicBenchmarkClient(t, barTemplate, nil)
+	s := runtime.NewScheme()
+	client := dynamicfake.NewSimpleDynamicClient(s)
+	client.PrependReactor("create", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("injected failure")
+	})
+	c.client = client.Resource(barGVR).Namespace(testNamespace)
+
+	err := ensureObjectCount(c, 3)
+	if err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Errorf("got error %v, want the failed create", err)
+	}
+}
+
+func TestDeleteCollectionSelectsRun(t *testing.T) {
+	for name, c := range fakeBenchmarkClients(t) {
+		withRunID("earlier", func() {
+			for i := 0; i < 2; i++ {
+				if _, err := c.Create(i); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+			}
+		})
+		withRunID("run1", func() {
+			for i := 0; i < 3; i++ {
+				if _, err := c.Create(i); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+			}
+			if n := mustLeftovers(t, c); n != 2 {
+				t.Errorf("%s: got %d leftovers, want 2", name, n)
+			}
+			if err := c.DeleteCollection(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if n := mustCount(t, c); n != 2 {
+				t.Errorf("%s: got %d objects after DeleteCollection, want the 2 of the earlier run", name, n)
+			}
+			if err := c.DeleteLeftovers(leftoverListOptions()); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if n := mustCount(t, c); n != 0 {
+				t.Errorf("%s: got %d objects after DeleteLeftovers, want 0", name, n)
+			}
+		})
+	}
+}
+
+func TestLeftoversExcludeUnlabeledObjects(t *testing.T) {
+	c := newFakeDynamicBenchmarkClient(t, barTemplate, nil)
+	unlabeled := c.template.DeepCopy()
+	unlabeled.SetName("unlabeled")
+	if _, err := c.client.Create(unlabeled, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	withRunID("run1", func() {
+		if _, err := c.Create(0); err != nil {
+			t.Fatal(err)
+		}
+		if n := mustLeftovers(t, c); n != 0 {
+			t.Errorf("got %d leftovers, want the unlabeled object left out", n)
+		}
+		n, err := c.Leftovers(unlabeledListOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("got %d unlabeled objects, want 1", n)
+		}
+	})
+}
+
+func TestCheckLeftovers(t *testing.T) {
+	oldLeftovers, oldUnlabeled := *purgeLeftovers, *purgeUnlabeled
+	defer func() { *purgeLeftovers, *purgeUnlabeled = oldLeftovers, oldUnlabeled }()
+
+	c := newFakeDynamicBenchmarkClient(t, barTemplate, nil)
+	unlabeled := c.template.DeepCopy()
+	unlabeled.SetName("unlabeled")
+	if _, err := c.client.Create(unlabeled, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	withRunID("earlier", func() {
+		if _, err := c.Create(0); err != nil {
+			t.Fatal(err)
+		}
+	})
+	withRunID("run1", func() {
+		*purgeLeftovers, *purgeUnlabeled = false, false
+		if err := checkLeftovers(c); err == nil || !strings.Contains(err.Error(), "--purge-leftovers") {
+			t.Errorf("got error %v, want the leftovers and --purge-leftovers", err)
+		}
+		*purgeLeftovers = true
+		if err := checkLeftovers(c); err == nil || !strings.Contains(err.Error(), "--purge-unlabeled") {
+			t.Errorf("got error %v, want the unlabeled object and --purge-unlabeled", err)
+		}
+		if n := mustCount(t, c); n != 1 {
+			t.Errorf("got %d objects, want the unlabeled object kept without --purge-unlabeled", n)
+		}
+		*purgeUnlabeled = true
+		if err := checkLeftovers(c); err != nil {
+			t.Fatal(err)
+		}
+		if n := mustCount(t, c); n != 0 {
+			t.Errorf("got %d objects after purging, want 0", n)
+		}
+	})
+}
+
+func TestVariationIsReproducible(t *testing.T) {
+	template, err := increaseObjectSize(barTemplate, 5, dummyFields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func() []byte {
+		var data []byte
+		withRunID("run1", func() {
+			c := newFakeDynamicBenchmarkClient(t, template, &objectVariation{seed: 1})
+			obj, err := c.Create(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := obj.(*unstructured.Unstructured)
+			data, err = u.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		return data
+	}
+	if a, b := create(), create(); string(a) != string(b) {
+		t.Errorf("objects varied with the same seed differ")
+	}
+}
+
+func TestGetTemplateSize(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{"Benchmark_CreateLatency_CR_LargeData", largeDataSize},
+		{"Benchmark_CreateLatency_CR_LargeMetadata", largeDataSize},
+		{"Benchmark_CreateLatency_Endpoints_LargeData", largeDataSize},
+		{"Benchmark_CreateLatency_CR_Size1KB", 1},
+		{"Benchmark_CreateLatency_CR_Size100KB", 100},
+		{"Benchmark_CreateLatency_Endpoints_Size100KB", 100},
+		{"Benchmark_CreateLatency_CR_PayloadNested", largeDataSize},
+	} {
+		size, _, err := templateSize(mustGetTemplate(t, tc.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// padding is added in chunks of about 1kB
+		if size < tc.size*1000 || size > tc.size*1000+1100 {
+			t.Errorf("%s: got %d bytes, want about %d", tc.name, size, tc.size*1000)
+		}
+	}
+	small, _, err := templateSize(mustGetTemplate(t, "Benchmark_CreateLatency_CR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small >= 1000 {
+		t.Errorf("got %d bytes for the unpadded template, want less than 1kB", small)
+	}
+}
+
+func TestValidationProbe(t *testing.T) {
+	mustProfile := func(profile string) *v1beta1.CustomResourceValidation {
+		v, err := newValidationProfile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		profile, previous string
+		// whether the previous profile accepts the probe
+		distinct bool
+	}{
+		{"Default", "Minimal", true},
+		{"Default", "", true},
+		{"Structural5", "Structural1", true},
+		{"Structural1", "Structural5", false},
+		{"StructuralDefaults5", "Structural5", false},
+	} {
+		validation := mustProfile(tc.profile)
+		var previous *v1beta1.CustomResourceValidation
+		var previousSchema *v1beta1.JSONSchemaProps
+		if tc.previous != "" {
+			previous = mustProfile(tc.previous)
+			previousSchema = previous.OpenAPIV3Schema
+		}
+		before, err := json.Marshal(validation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		probe := validationProbe(validation, previous)
+		if probe == nil {
+			t.Errorf("%s after %s: no probe", tc.profile, tc.previous)
+			continue
+		}
+		if after, _ := json.Marshal(validation); string(after) != string(before) {
+			t.Errorf("%s: probing changed the schema", tc.profile)
+		}
+		accepted := false
+		for _, property := range typedProperties(validation.OpenAPIV3Schema, nil) {
+			value, found, _ := unstructured.NestedFieldNoCopy(probe.Object, property.path...)
+			if _, object := value.(map[string]interface{}); !found || object {
+				continue
+			}
+			if mayAccept(validation.OpenAPIV3Schema, property.path, value) {
+				t.Errorf("%s: probe %v has an accepted value at %v", tc.profile, probe.Object, property.path)
+			}
+			accepted = mayAccept(previousSchema, property.path, value)
+			break
+		}
+		if accepted != tc.distinct {
+			t.Errorf("%s after %s: previous schema accepts probe %v: %v, want %v", tc.profile, tc.previous, probe.Object, accepted, tc.distinct)
+		}
+	}
+	if probe := validationProbe(nil, mustNewValidation()); probe != nil {
+		t.Errorf("got probe %v without validation", probe.Object)
+	}
+}
+
+func TestConversionProbe(t *testing.T) {
+	probe, err := decodeUnstructured(conversionProbe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := convertFoo(probe, "stable.example.com/v2"); err == nil {
+		t.Errorf("converted probe %v to v2", probe.Object)
+	}
+}
+
+func TestProfileDefaults(t *testing.T) {
+	for profile, want := range map[string]bool{
+		"StructuralDefaults3": true,
+		"Structural3":         false,
+		"PruneUnknown":        false,
+		"Default":             false,
+	} {
+		v, err := newValidationProfile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hasDefaults(v.OpenAPIV3Schema); got != want {
+			t.Errorf("%s: got defaults %v, want %v", profile, got, want)
+		}
+	}
+	for profile, want := range map[string][2]bool{
+		"":                 {false, false},
+		"Regex":            {true, false},
+		"KeepUnknown":      {false, false},
+		"PruneUnknown":     {true, true},
+		"Structural3":      {true, true},
+		"/tmp/schema.yaml": {true, false},
+	} {
+		if prune, required := profilePruning(profile); prune != want[0] || required != want[1] {
+			t.Errorf("%q: got pruning %v required %v, want %v %v", profile, prune, required, want[0], want[1])
+		}
+	}
+}
+
+func TestValidationObject(t *testing.T) {
+	v, err := newValidationObject("PreserveUnknownFields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newer than the vendored types, which drop it
+	if preserve, _, _ := unstructured.NestedBool(v, "openAPIV3Schema", "properties", "spec", "x-kubernetes-preserve-unknown-fields"); !preserve {
+		t.Errorf("x-kubernetes-preserve-unknown-fields dropped from %v", v)
+	}
+	v, err = newValidationObject("ManyProperties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxLength, _, _ := unstructured.NestedFieldNoCopy(v, "openAPIV3Schema", "properties", "spec", "properties", "field000", "maxLength")
+	if _, ok := maxLength.(int64); !ok {
+		t.Errorf("got maxLength %v of type %T, want an int64 like the dynamic client decodes", maxLength, maxLength)
+	}
+}
+
+func TestVariationVariesArrays(t *testing.T) {
+	template, err := increaseObjectSize(barTemplate, 50, dummyFields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := decodeUnstructured(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ := unstructured.NestedSlice(original.Object, "spec", "dummy")
+	size, err := marshaledSize(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shorter, longer := false, false
+	v := &objectVariation{seed: 1}
+	for i := 0; i < 20; i++ {
+		u := original.DeepCopy()
+		v.varyUnstructured(u)
+		got, _, _ := unstructured.NestedSlice(u.Object, "spec", "dummy")
+		shorter = shorter || len(got) < len(want)
+		longer = longer || len(got) > len(want)
+		// within one dummy item of the template
+		if varied, _ := marshaledSize(u); varied < size-1000 || varied > size+1000 {
+			t.Errorf("varied object has %d bytes, want about %d", varied, size)
+		}
+	}
+	if !shorter || !longer {
+		t.Errorf("got shorter arrays %v, longer arrays %v, want both", shorter, longer)
+	}
+}
+
+func TestVariationVariesEndpoints(t *testing.T) {
+	template, err := addEndpointsAddresses(endpointsTemplate, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := &v1.Endpoints{}
+	if err := yaml.Unmarshal(template, original); err != nil {
+		t.Fatal(err)
+	}
+	size, err := endpointsSize(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lengths := map[int]bool{}
+	v := &objectVariation{seed: 1}
+	for i := 0; i < 20; i++ {
+		e := original.DeepCopy()
+		v.varyEndpoints(e)
+		lengths[len(e.Subsets[0].Addresses)] = true
+		if varied, _ := endpointsSize(e); varied < size-100 || varied > size+100 {
+			t.Errorf("varied endpoints have %d bytes, want about %d", varied, size)
+		}
+	}
+	if len(lengths) < 2 {
+		t.Errorf("got address counts %v, want them to vary", lengths)
+	}
+}
+
+func TestGetNamespace(t *testing.T) {
+	for name, want := range map[string]string{
+		"Benchmark_List_CR":                        emptyNamespace,
+		"Benchmark_List_CR_LargeData":              largeDataNamespace,
+		"Benchmark_List_CR_Size100KB":              sizeNamespace(100),
+		"Benchmark_CreateLatency_CR_PayloadNested": payloadNamespace("Nested", largeDataSize),
+	} {
+		if got := getNamespace(name); got != want {
+			t.Errorf("%s: got namespace %s, want %s", name, got, want)
+		}
+	}
+}
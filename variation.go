@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var (
+	variationSeed = flag.Int64("variation-seed", 1, "seed of the per-object variation in Varied scenarios, the same seed creates the same objects")
+
+	// label keys objects get a random subset of
+	variationLabels = []string{"app", "tier", "zone", "team", "release"}
+	// fields under spec whose values are generated rather than checked by a
+	// validation profile, so they can be rewritten freely
+	variedSpecFields = map[string]bool{"dummy": true, "payload": true}
+
+	// annotation that pads varied objects to the size of their template
+	variationPaddingAnnotation = "stable.example.com/variation-padding"
+)
+
+// objectVariation changes every object created from a template, so that
+// objects don't all serialize, cache and compress the same way. The n-th object
+// of a client is varied with seed+n, so a seed reproduces the same objects, in
+// the same order unless they are created concurrently.
+type objectVariation struct {
+	seed int64
+	// number of objects varied so far, updated atomically
+	count int64
+}
+
+// getVariation returns the variation of Varied scenarios, or nil if objects
+// are created from the template as is
+func getVariation(name string) *objectVariation {
+	if !strings.Contains(name, "Varied") {
+		return nil
+	}
+	return &objectVariation{seed: *variationSeed}
+}
+
+func (v *objectVariation) next() *rand.Rand {
+	return rand.New(rand.NewSource(v.seed + atomic.AddInt64(&v.count, 1)))
+}
+
+// varyUnstructured sets a random subset of variationLabels, changes the length
+// of arrays under spec, and rewrites the digits and numbers of generated spec
+// fields. The object keeps the size of the template: if it grew, the strings
+// of generated spec fields are shortened, and then grown arrays cut back, and
+// if it shrank, it is padded with variationPaddingAnnotation.
+func (v *objectVariation) varyUnstructured(u *unstructured.Unstructured) {
+	r := v.next()
+	size, err := marshaledSize(u)
+	if err != nil {
+		size = -1
+	}
+	u.SetLabels(variedLabels(u.GetLabels(), r))
+	spec, ok := u.Object["spec"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	lengths := map[string]int{}
+	for _, k := range sortedKeys(spec) {
+		if items, ok := spec[k].([]interface{}); ok {
+			lengths[k] = len(items)
+		}
+		spec[k] = varyValue(spec[k], r, variedSpecFields[k])
+	}
+	if size < 0 {
+		return
+	}
+	// grown arrays are made up for by shorter generated strings first, so
+	// that they keep their length
+	if current, err := marshaledSize(u); err == nil && current > size {
+		trimmableLength := 0
+		for k := range variedSpecFields {
+			trimmableLength += trimmable(spec[k])
+		}
+		if trimmableLength > 0 {
+			fraction := math.Min(1, float64(current-size)/float64(trimmableLength))
+			for k := range variedSpecFields {
+				if value, ok := spec[k]; ok {
+					spec[k] = trimStrings(value, fraction)
+				}
+			}
+		}
+	}
+	// arrays are cut back in the order of their names, like they were varied
+	for _, k := range sortedKeys(spec) {
+		items, ok := spec[k].([]interface{})
+		if !ok {
+			continue
+		}
+		spec[k] = items[:keepSize(len(items), lengths[k], size, func() (int, error) {
+			return marshaledSize(u)
+		}, func(i int) (int, error) {
+			data, err := json.Marshal(items[i])
+			return len(data), err
+		})]
+	}
+	u.SetAnnotations(padToSize(u.GetAnnotations(), size, func(annotations map[string]string) (int, error) {
+		u.SetAnnotations(annotations)
+		return marshaledSize(u)
+	}))
+}
+
+// varyEndpoints sets a random subset of variationLabels, and changes the
+// length of the address lists. The object keeps the size of the template like
+// in varyUnstructured, with node names left out of addresses rather than
+// strings shortened.
+func (v *objectVariation) varyEndpoints(e *v1.Endpoints) {
+	r := v.next()
+	size, err := endpointsSize(e)
+	if err != nil {
+		size = -1
+	}
+	e.Labels = variedLabels(e.Labels, r)
+	lengths := make([]int, len(e.Subsets))
+	for i := range e.Subsets {
+		addresses := e.Subsets[i].Addresses
+		lengths[i] = len(addresses)
+		if len(addresses) == 0 {
+			continue
+		}
+		varied := make([]v1.EndpointAddress, variedLength(len(addresses), r))
+		offset := rotation(len(addresses), r)
+		for j := range varied {
+			varied[j] = *addresses[(j+offset)%len(addresses)].DeepCopy()
+		}
+		e.Subsets[i].Addresses = varied
+	}
+	if size < 0 {
+		return
+	}
+	// grown address lists are made up for by addresses without a node name
+	// first, so that they keep their length
+	if current, err := endpointsSize(e); err == nil && current > size {
+		for i := len(e.Subsets) - 1; i >= 0 && current > size; i-- {
+			addresses := e.Subsets[i].Addresses
+			for j := len(addresses) - 1; j >= 0 && current > size; j-- {
+				if addresses[j].NodeName != nil {
+					// ,"nodeName":"<name>"
+					current -= len(`,"nodeName":""`) + len(*addresses[j].NodeName)
+					addresses[j].NodeName = nil
+				}
+			}
+		}
+	}
+	for i := range e.Subsets {
+		addresses := e.Subsets[i].Addresses
+		e.Subsets[i].Addresses = addresses[:keepSize(len(addresses), lengths[i], size, func() (int, error) {
+			return endpointsSize(e)
+		}, func(j int) (int, error) {
+			data, err := json.Marshal(&addresses[j])
+			return len(data), err
+		})]
+	}
+	e.Annotations = padToSize(e.Annotations, size, func(annotations map[string]string) (int, error) {
+		e.Annotations = annotations
+		return endpointsSize(e)
+	})
+}
+
+// endpointsSize is the size of e as JSON
+func endpointsSize(e *v1.Endpoints) (int, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// keepSize returns the length an array of length n that grew from length
+// original is cut back to, so that the object it is in, of size as measured by
+// measure, isn't larger than size any more. itemSize measures the i-th item.
+// Arrays that shrank, or grew without the object growing, are kept as they
+// are, so that varied objects have arrays both shorter and longer than the
+// template.
+func keepSize(n, original, size int, measure func() (int, error), itemSize func(i int) (int, error)) int {
+	current, err := measure()
+	if err != nil {
+		return n
+	}
+	for n > original && current > size {
+		item, err := itemSize(n - 1)
+		if err != nil {
+			return n
+		}
+		// with the comma separating it from the previous item
+		current -= item + 1
+		n--
+	}
+	return n
+}
+
+// minTrimmedLength is the length trimStrings keeps of generated strings
+const minTrimmedLength = 16
+
+// trimmable is the length trimStrings can cut from the strings in value
+func trimmable(value interface{}) int {
+	n := 0
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			n += trimmable(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			n += trimmable(item)
+		}
+	case string:
+		if len(v) > minTrimmedLength {
+			n = len(v) - minTrimmedLength
+		}
+	}
+	return n
+}
+
+// trimStrings cuts fraction of their trimmable length from the end of the
+// strings in value, rounded up, in place
+func trimStrings(value interface{}, fraction float64) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = trimStrings(item, fraction)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = trimStrings(item, fraction)
+		}
+	case string:
+		if len(v) > minTrimmedLength {
+			return v[:len(v)-int(math.Ceil(float64(len(v)-minTrimmedLength)*fraction))]
+		}
+	}
+	return value
+}
+
+// padToSize returns annotations with variationPaddingAnnotation padding the
+// object, of size as measured by measure with given annotations, to size. An
+// object that is already as large is left as it is.
+func padToSize(annotations map[string]string, size int, measure func(annotations map[string]string) (int, error)) map[string]string {
+	padded := map[string]string{}
+	for k, v := range annotations {
+		padded[k] = v
+	}
+	padded[variationPaddingAnnotation] = ""
+	current, err := measure(padded)
+	if err != nil || current >= size {
+		return annotations
+	}
+	padded[variationPaddingAnnotation] = strings.Repeat("x", size-current)
+	return padded
+}
+
+func variedLabels(labels map[string]string, r *rand.Rand) map[string]string {
+	varied := map[string]string{}
+	for k, v := range labels {
+		varied[k] = v
+	}
+	for _, k := range variationLabels {
+		if r.Intn(2) == 0 {
+			varied[k] = fmt.Sprintf("%s-%d", k, r.Intn(10))
+		}
+	}
+	return varied
+}
+
+// varyValue returns a varied copy of a JSON value. Leaf values are only
+// rewritten if rewrite is true, as validation profiles may restrict them.
+func varyValue(value interface{}, r *rand.Rand, rewrite bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		varied := make(map[string]interface{}, len(v))
+		// keys are sorted so that a seed always draws the same numbers
+		for _, k := range sortedKeys(v) {
+			varied[k] = varyValue(v[k], r, rewrite)
+		}
+		return varied
+	case []interface{}:
+		if len(v) == 0 {
+			return []interface{}{}
+		}
+		// items are repeated from the rotated array to grow it
+		varied := make([]interface{}, variedLength(len(v), r))
+		offset := rotation(len(v), r)
+		for i := range varied {
+			varied[i] = varyValue(v[(i+offset)%len(v)], r, rewrite)
+		}
+		return varied
+	case string:
+		if !rewrite {
+			return v
+		}
+		return rewriteDigits(v, r)
+	case int64:
+		if !rewrite {
+			return v
+		}
+		// at most as many digits, so the size of the object doesn't grow
+		n, err := strconv.ParseInt(rewriteDigits(strconv.FormatInt(v, 10), r), 10, 64)
+		if err != nil {
+			return v
+		}
+		return n
+	case float64:
+		if !rewrite {
+			return v
+		}
+		// at most as many digits, as for integers
+		f, err := strconv.ParseFloat(rewriteDigits(strconv.FormatFloat(v, 'g', -1, 64), r), 64)
+		if err != nil {
+			return v
+		}
+		return f
+	default:
+		return v
+	}
+}
+
+func rewriteDigits(s string, r *rand.Rand) string {
+	return strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return '0' + rune(r.Intn(10))
+		}
+		return c
+	}, s)
+}
+
+// variedLength picks a length around n, shorter or longer by up to a quarter
+// of n, or by 1 for short arrays
+func variedLength(n int, r *rand.Rand) int {
+	if n == 0 {
+		return 0
+	}
+	spread := n / 4
+	if spread < 1 {
+		spread = 1
+	}
+	return n - spread + r.Intn(2*spread+1)
+}
+
+// rotation picks an offset to rotate a list of length n by
+func rotation(n int, r *rand.Rand) int {
+	if n == 0 {
+		return 0
+	}
+	return r.Intn(n)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// convertFunc converts obj in place to the given apiVersion
+type convertFunc func(obj *unstructured.Unstructured, toAPIVersion string) error
+
+// runWebhook serves CRD conversion for the Foo and Chain CRDs, and admission
+// for foos and bars
+func runWebhook(args []string) {
+	fs := flag.NewFlagSet("webhook", flag.ExitOnError)
+	certFile := fs.String("tls-cert-file", "/var/certs/cert.pem", "file containing the x509 serving certificate")
+	keyFile := fs.String("tls-private-key-file", "/var/certs/key.pem", "file containing the x509 private key matching --tls-cert-file")
+	port := fs.Int("port", 443, "secure port the webhook listens on")
+	chainVersions := fs.Int("chain-versions", 4, "number of Chain versions, the newest one is the hub all conversions go through")
+	reloadInterval := fs.Duration("cert-reload-interval", 10*time.Second, "how often to check the certificate files for a rotated certificate")
+	profiling := fs.Bool("profiling", false, "serve pprof profiles on /debug/pprof/ of the webhook port, unauthenticated, for --profile of benchmarks")
+	fs.Parse(args)
+
+	if err := serveWebhook(fmt.Sprintf(":%d", *port), *certFile, *keyFile, *chainVersions, *reloadInterval, *profiling); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serveWebhook serves the conversion and admission webhooks on addr until it
+// fails, and pprof profiles if profiling is set
+func serveWebhook(addr, certFile, keyFile string, chainVersions int, reloadInterval time.Duration, profiling bool) error {
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	go certs.run(reloadInterval)
+
+	mux := http.NewServeMux()
+	mux.Handle("/crdconvert", conversionHandler(convertFoo))
+	mux.Handle("/chainconvert", conversionHandler(newChainConverter(chainVersions)))
+	mux.Handle(validatePath, admissionHandler(validateObject))
+	mux.Handle(mutatePath, admissionHandler(mutateObject))
+	if profiling {
+		// the named profiles, e.g. heap and goroutine, are served by Index
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: &tls.Config{GetCertificate: certs.GetCertificate},
+	}
+	fmt.Printf("serving conversion and admission webhooks on %s\n", server.Addr)
+	return server.ListenAndServeTLS("", "")
+}
+
+// certReloader serves the certificate from the given files, reloading it when
+// the files change, e.g. when the kubelet updates a mounted secret. Open
+// connections keep the certificate they were established with.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate files if they differ from the served certificate
+func (r *certReloader) reload() error {
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.lock.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	// the files are not updated atomically, a mismatched pair is retried on the next tick
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.cert, r.certPEM, r.keyPEM = &cert, certPEM, keyPEM
+	r.lock.Unlock()
+	fmt.Printf("loaded serving certificate from %s\n", r.certFile)
+	return nil
+}
+
+func (r *certReloader) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := r.reload(); err != nil {
+			fmt.Printf("failed to reload serving certificate: %v\n", err)
+		}
+	}
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// conversionHandler serves ConversionReviews using convert on every object
+func conversionHandler(convert convertFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review := v1beta1.ConversionReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode conversion review: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "conversion review has no request", http.StatusBadRequest)
+			return
+		}
+		review.Response = convertObjects(review.Request, convert)
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&review); err != nil {
+			fmt.Printf("failed to write conversion response: %v\n", err)
+		}
+	}
+}
+
+func convertObjects(req *v1beta1.ConversionRequest, convert convertFunc) *v1beta1.ConversionResponse {
+	resp := &v1beta1.ConversionResponse{UID: req.UID}
+	for _, raw := range req.Objects {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+			return conversionFailure(req, err)
+		}
+		if err := convert(obj, req.DesiredAPIVersion); err != nil {
+			return conversionFailure(req, err)
+		}
+		obj.SetAPIVersion(req.DesiredAPIVersion)
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return conversionFailure(req, err)
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: data})
+	}
+	resp.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return resp
+}
+
+func conversionFailure(req *v1beta1.ConversionRequest, err error) *v1beta1.ConversionResponse {
+	return &v1beta1.ConversionResponse{
+		UID:    req.UID,
+		Result: metav1.Status{Status: metav1.StatusFailure, Message: err.Error()},
+	}
+}
+
+// convertFoo converts between Foo v1, which has a "hostPort" field, and Foo v2,
+// which splits it into "host" and "port", the same way the e2e
+// crd-conversion-webhook image does
+func convertFoo(obj *unstructured.Unstructured, toAPIVersion string) error {
+	fromAPIVersion := obj.GetAPIVersion()
+	if fromAPIVersion == toAPIVersion {
+		return fmt.Errorf("conversion from a version to itself should not call the webhook: %s", toAPIVersion)
+	}
+	switch fromAPIVersion {
+	case "stable.example.com/v1":
+		if toAPIVersion != "stable.example.com/v2" {
+			return fmt.Errorf("unexpected conversion version %q", toAPIVersion)
+		}
+		hostPort, ok := obj.Object["hostPort"]
+		if !ok {
+			return nil
+		}
+		delete(obj.Object, "hostPort")
+		parts := strings.Split(fmt.Sprint(hostPort), ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid hostPort value %q", hostPort)
+		}
+		obj.Object["host"] = parts[0]
+		obj.Object["port"] = parts[1]
+	case "stable.example.com/v2":
+		if toAPIVersion != "stable.example.com/v1" {
+			return fmt.Errorf("unexpected conversion version %q", toAPIVersion)
+		}
+		host, hasHost := obj.Object["host"]
+		port, hasPort := obj.Object["port"]
+		if !hasHost && !hasPort {
+			return nil
+		}
+		delete(obj.Object, "host")
+		delete(obj.Object, "port")
+		obj.Object["hostPort"] = fmt.Sprintf("%v:%v", host, port)
+	default:
+		return fmt.Errorf("unexpected conversion version %q", fromAPIVersion)
+	}
+	return nil
+}